@@ -0,0 +1,400 @@
+package fastglue
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/valyala/fasthttp"
+)
+
+const (
+	// defaultArgsMaxDepth is the default maximum nesting depth of bracketed arg keys.
+	defaultArgsMaxDepth = 10
+
+	// defaultArgsMaxKeys is the default maximum number of args that are decoded.
+	defaultArgsMaxKeys = 1000
+)
+
+var jsonUnmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+
+// ArgsOptions represents the options for UnmarshalArgsWith.
+type ArgsOptions struct {
+	// MaxDepth is the maximum nesting depth of keys, eg: a[b][c] has a depth of 2.
+	// Defaults to 10.
+	MaxDepth int
+
+	// MaxKeys is the maximum number of args. Defaults to 1000.
+	MaxKeys int
+
+	// Strict fails decoding with an error on the first arg with a malformed key
+	// (eg: a[b) or a key that conflicts with another (eg: a=1&a[b]=2) instead of
+	// skipping such args.
+	Strict bool
+}
+
+// UnmarshalArgs decodes a fasthttp.Args set with bracket notation keys, as posted
+// by browsers and many HTTP clients, into the given (nested) struct. Fields are mapped
+// by the given tag the same way as ScanArgs. For instance,
+//
+//	user[name]=x&user[tags][]=a&user[tags][]=b&items[0][qty]=1&items[1][qty]=2
+//
+// decodes into
+//
+//	type Order struct {
+//		User struct {
+//			Name string   `url:"name"`
+//			Tags []string `url:"tags"`
+//		} `url:"user"`
+//		Items []struct {
+//			Qty int `url:"qty"`
+//		} `url:"items"`
+//	}
+//
+// Keys with contiguous integer indices starting at 0 or 1 are decoded into slices and
+// other keys into structs or maps (with string keys). Args with malformed keys are skipped
+// unless ArgsOptions.Strict is set.
+// The nesting depth and the number of args are limited as per the default ArgsOptions.
+func UnmarshalArgs(args *fasthttp.Args, obj interface{}, fieldTag string) error {
+	return UnmarshalArgsWith(args, obj, fieldTag, ArgsOptions{})
+}
+
+// UnmarshalArgsWith is the same as UnmarshalArgs but with the given options.
+func UnmarshalArgsWith(args *fasthttp.Args, obj interface{}, fieldTag string, o ArgsOptions) error {
+	if o.MaxDepth <= 0 {
+		o.MaxDepth = defaultArgsMaxDepth
+	}
+	if o.MaxKeys <= 0 {
+		o.MaxKeys = defaultArgsMaxKeys
+	}
+
+	ob := reflect.ValueOf(obj)
+	if ob.Kind() != reflect.Ptr || ob.IsNil() {
+		return fmt.Errorf("failed to decode args, expected a non-nil pointer: %T", obj)
+	}
+
+	if n := args.Len(); n > o.MaxKeys {
+		return fmt.Errorf("failed to decode args, too many args: %d > %d", n, o.MaxKeys)
+	}
+
+	var (
+		root = map[string]interface{}{}
+		err  error
+	)
+	args.VisitAll(func(k, v []byte) {
+		if err != nil {
+			return
+		}
+
+		keys, e := parseArgKey(string(k))
+		if e != nil {
+			// Malformed keys are skipped unless in strict mode.
+			if o.Strict {
+				err = fmt.Errorf("failed to decode args, %v", e)
+			}
+			return
+		}
+		if len(keys)-1 > o.MaxDepth {
+			err = fmt.Errorf("failed to decode args, `%s` exceeds the max depth of %d", k, o.MaxDepth)
+			return
+		}
+
+		// Conflicting keys (eg: a=1&a[b]=2) are skipped unless in strict mode.
+		if e := merge(root, queryToMap(keys, string(v))); e != nil && o.Strict {
+			err = fmt.Errorf("failed to decode args, %v", e)
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	return assignArg(toSlices(root), ob.Elem(), fieldTag, "")
+}
+
+// parseArgKey splits a bracket notation key such as a[b][0][] into
+// its parts, ["a", "b", "0", ""]. An empty part denotes an append.
+func parseArgKey(key string) ([]string, error) {
+	i := strings.IndexByte(key, '[')
+	if i < 0 {
+		if strings.IndexByte(key, ']') >= 0 {
+			return nil, fmt.Errorf("malformed key: %s", key)
+		}
+		return []string{key}, nil
+	}
+	if i == 0 || strings.IndexByte(key[:i], ']') >= 0 {
+		return nil, fmt.Errorf("malformed key: %s", key)
+	}
+
+	var (
+		out  = []string{key[:i]}
+		rest = key[i:]
+	)
+	for len(rest) > 0 {
+		if rest[0] != '[' {
+			return nil, fmt.Errorf("malformed key: %s", key)
+		}
+		end := strings.IndexByte(rest, ']')
+		if end < 0 || strings.IndexByte(rest[1:end], '[') >= 0 {
+			return nil, fmt.Errorf("malformed key: %s", key)
+		}
+		out = append(out, rest[1:end])
+		rest = rest[end+1:]
+	}
+
+	// Appends ([]) are only allowed at the end.
+	for _, k := range out[1 : len(out)-1] {
+		if k == "" {
+			return nil, fmt.Errorf("malformed key: %s", key)
+		}
+	}
+
+	return out, nil
+}
+
+// queryToMap converts the parts of a key and its value into a nested map,
+// eg: [a, b] and v to {a: {b: v}}. Appends ([]) are represented as lists.
+func queryToMap(keys []string, val string) map[string]interface{} {
+	var node interface{} = val
+	for i := len(keys) - 1; i > 0; i-- {
+		if keys[i] == "" {
+			node = []interface{}{node}
+			continue
+		}
+		node = map[string]interface{}{keys[i]: node}
+	}
+
+	return map[string]interface{}{keys[0]: node}
+}
+
+// merge deep merges the nested map src into dst. Repeated values of
+// the same key are collected into a list.
+func merge(dst, src map[string]interface{}) error {
+	for k, sv := range src {
+		dv, ok := dst[k]
+		if !ok {
+			dst[k] = sv
+			continue
+		}
+
+		dm, dIsMap := dv.(map[string]interface{})
+		sm, sIsMap := sv.(map[string]interface{})
+		switch {
+		case dIsMap && sIsMap:
+			if err := merge(dm, sm); err != nil {
+				return err
+			}
+		case dIsMap || sIsMap:
+			return fmt.Errorf("conflicting values for key: %s", k)
+		default:
+			dst[k] = append(toList(dv), toList(sv)...)
+		}
+	}
+
+	return nil
+}
+
+func toList(v interface{}) []interface{} {
+	if l, ok := v.([]interface{}); ok {
+		return l
+	}
+	return []interface{}{v}
+}
+
+// toSlices recursively converts maps whose keys are all contiguous integer
+// indices starting at 0 or 1 into lists ordered by the indices.
+func toSlices(node interface{}) interface{} {
+	switch n := node.(type) {
+	case []interface{}:
+		for i, v := range n {
+			n[i] = toSlices(v)
+		}
+		return n
+
+	case map[string]interface{}:
+		idx := make([]int, 0, len(n))
+		for k, v := range n {
+			n[k] = toSlices(v)
+
+			if i, err := strconv.Atoi(k); err == nil && i >= 0 && strconv.Itoa(i) == k {
+				idx = append(idx, i)
+			}
+		}
+		if len(idx) == 0 || len(idx) != len(n) {
+			return n
+		}
+
+		sort.Ints(idx)
+		start := idx[0]
+		if start > 1 || idx[len(idx)-1] != start+len(idx)-1 {
+			return n
+		}
+
+		out := make([]interface{}, len(idx))
+		for _, i := range idx {
+			out[i-start] = n[strconv.Itoa(i)]
+		}
+		return out
+	}
+
+	return node
+}
+
+// assignArg assigns a node of the nested map of args (a string value, a list
+// or a map) to the value f. path is the bracket notation key of the node for errors.
+func assignArg(node interface{}, f reflect.Value, fieldTag, path string) error {
+	// Scalars and types that unmarshal themselves take a single value.
+	if s, ok := node.(string); ok {
+		return assignArgVal(s, f, path)
+	}
+
+	if f.Kind() == reflect.Ptr && !isArgUnmarshaler(f) {
+		v := reflect.New(f.Type().Elem())
+		if err := assignArg(node, v.Elem(), fieldTag, path); err != nil {
+			return err
+		}
+		f.Set(v)
+		return nil
+	}
+
+	switch n := node.(type) {
+	case []interface{}:
+		if f.Kind() != reflect.Slice || isArgUnmarshaler(f) || f.Type().Elem().Kind() == reflect.Uint8 {
+			// Take the first value, like ScanArgs does, for non-slices.
+			if s, ok := n[0].(string); ok {
+				return assignArgVal(s, f, path)
+			}
+			return fmt.Errorf("failed to decode `%s`, expected a value", path)
+		}
+
+		sl := reflect.MakeSlice(f.Type(), len(n), len(n))
+		for i, v := range n {
+			if err := assignArg(v, sl.Index(i), fieldTag, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+		f.Set(sl)
+
+	case map[string]interface{}:
+		switch {
+		case f.Kind() == reflect.Struct && !isArgUnmarshaler(f):
+			return assignArgStruct(n, f, fieldTag, path)
+
+		case f.Kind() == reflect.Map && f.Type().Key().Kind() == reflect.String:
+			if f.IsNil() {
+				f.Set(reflect.MakeMap(f.Type()))
+			}
+			for k, v := range n {
+				el := reflect.New(f.Type().Elem()).Elem()
+				if err := assignArg(v, el, fieldTag, argPath(path, k)); err != nil {
+					return err
+				}
+				f.SetMapIndex(reflect.ValueOf(k).Convert(f.Type().Key()), el)
+			}
+
+		case f.Kind() == reflect.Slice:
+			return fmt.Errorf("failed to decode `%s`, expected contiguous indices for a list", path)
+
+		default:
+			return fmt.Errorf("failed to decode `%s`, expected a value", path)
+		}
+	}
+
+	return nil
+}
+
+// assignArgStruct assigns the keys of a map to the tagged fields of a struct.
+func assignArgStruct(m map[string]interface{}, ob reflect.Value, fieldTag, path string) error {
+	for i := 0; i < ob.NumField(); i++ {
+		var (
+			f  = ob.Field(i)
+			sf = ob.Type().Field(i)
+		)
+		if !f.CanSet() {
+			continue
+		}
+
+		tag := strings.Split(sf.Tag.Get(fieldTag), ",")[0]
+
+		// Untagged embedded structs are flattened into the same namespace.
+		if tag == "" && sf.Anonymous {
+			if err := assignArgEmbedded(m, f, fieldTag, path); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if tag == "" || tag == "-" {
+			continue
+		}
+
+		node, ok := m[tag]
+		if !ok {
+			continue
+		}
+		if err := assignArg(node, f, fieldTag, argPath(path, tag)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// assignArgEmbedded assigns the keys of a map to an embedded struct (or a pointer
+// to one). A nil pointer is only allocated if one of its fields is assigned.
+func assignArgEmbedded(m map[string]interface{}, f reflect.Value, fieldTag, path string) error {
+	switch {
+	case f.Kind() == reflect.Struct && !isArgUnmarshaler(f):
+		return assignArgStruct(m, f, fieldTag, path)
+
+	case f.Kind() == reflect.Ptr && f.Type().Elem().Kind() == reflect.Struct:
+		v := f
+		if f.IsNil() {
+			v = reflect.New(f.Type().Elem())
+		}
+		if err := assignArgStruct(m, v.Elem(), fieldTag, path); err != nil {
+			return err
+		}
+		if f.IsNil() && !v.Elem().IsZero() {
+			f.Set(v)
+		}
+	}
+
+	return nil
+}
+
+// assignArgVal assigns a single string value to f.
+func assignArgVal(val string, f reflect.Value, path string) error {
+	switch {
+	case f.Kind() == reflect.Slice && f.Type().Elem().Kind() == reflect.Uint8 && !isArgUnmarshaler(f):
+		f.SetBytes([]byte(val))
+		return nil
+
+	case f.Kind() == reflect.Slice && !isArgUnmarshaler(f):
+		sl := reflect.MakeSlice(f.Type(), 1, 1)
+		if err := assignArgVal(val, sl.Index(0), path+"[0]"); err != nil {
+			return err
+		}
+		f.Set(sl)
+		return nil
+
+	case f.Kind() == reflect.Map || (f.Kind() == reflect.Struct && !isArgUnmarshaler(f) &&
+		!reflect.PtrTo(f.Type()).Implements(jsonUnmarshalerType)):
+		return fmt.Errorf("failed to decode `%s`, got: `%s` (expected nested keys)", path, val)
+	}
+
+	if _, err := setVal(f, val); err != nil {
+		return fmt.Errorf("failed to decode `%s`, got: `%s` (%v)", path, val, err)
+	}
+	return nil
+}
+
+// argPath returns the bracket notation path of key under path.
+func argPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "[" + key + "]"
+}
@@ -0,0 +1,135 @@
+package fastglue
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/valyala/fasthttp"
+)
+
+type formItem struct {
+	Name string `url:"name"`
+	Qty  int    `url:"qty"`
+}
+
+type formOrder struct {
+	User struct {
+		Name  string            `url:"name"`
+		Tags  []string          `url:"tags"`
+		Attrs map[string]string `url:"attrs"`
+	} `url:"user"`
+	Items []formItem  `url:"items"`
+	Legs  []*formItem `url:"legs"`
+	Note  *string     `url:"note"`
+	IDs   []int       `url:"ids"`
+
+	Pagination
+}
+
+func TestUnmarshalArgs(t *testing.T) {
+	args := fasthttp.AcquireArgs()
+	defer fasthttp.ReleaseArgs(args)
+	args.Parse("user[name]=test&user[tags][]=a&user[tags][]=b&user[attrs][k]=v" +
+		"&items[1][name]=y&items[0][name]=x&items[0][qty]=1&items[1][qty]=2" +
+		"&legs[1][name]=first&legs[2][name]=second" +
+		"&note=hi&ids=1&ids=2&page=3&bad[=1&x]y=2")
+
+	var o formOrder
+	require.NoError(t, UnmarshalArgs(args, &o, "url"))
+
+	require.Equal(t, "test", o.User.Name)
+	require.Equal(t, []string{"a", "b"}, o.User.Tags)
+	require.Equal(t, map[string]string{"k": "v"}, o.User.Attrs)
+	require.Equal(t, []formItem{{Name: "x", Qty: 1}, {Name: "y", Qty: 2}}, o.Items)
+	require.Equal(t, []*formItem{{Name: "first"}, {Name: "second"}}, o.Legs)
+	require.Equal(t, "hi", *o.Note)
+	require.Equal(t, []int{1, 2}, o.IDs)
+	require.Equal(t, 3, o.Page)
+
+	// Bad values.
+	args.Parse("items[0][qty]=one")
+	err := UnmarshalArgs(args, &o, "url")
+	require.EqualError(t, err, "failed to decode `items[0][qty]`, got: `one` (expected int)")
+
+	// Non-contiguous indices can't be decoded into lists.
+	args.Parse("items[0][qty]=1&items[5][qty]=2")
+	require.Error(t, UnmarshalArgs(args, &o, "url"))
+}
+
+func TestUnmarshalArgsLimits(t *testing.T) {
+	args := fasthttp.AcquireArgs()
+	defer fasthttp.ReleaseArgs(args)
+
+	args.Parse("user" + strings.Repeat("[a]", 11) + "=1")
+	err := UnmarshalArgs(args, &formOrder{}, "url")
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "max depth")
+
+	args.Parse("a[b][c]=1")
+	err = UnmarshalArgsWith(args, &formOrder{}, "url", ArgsOptions{MaxDepth: 1})
+	require.Contains(t, err.Error(), "max depth")
+
+	q := url.Values{}
+	for i := 0; i < 20; i++ {
+		q.Add("ids", "1")
+	}
+	args.Parse(q.Encode())
+	err = UnmarshalArgsWith(args, &formOrder{}, "url", ArgsOptions{MaxKeys: 10})
+	require.Contains(t, err.Error(), "too many args")
+}
+
+func TestUnmarshalArgsStrict(t *testing.T) {
+	args := fasthttp.AcquireArgs()
+	defer fasthttp.ReleaseArgs(args)
+	strict := ArgsOptions{Strict: true}
+
+	// Malformed keys are skipped by default.
+	args.Parse("user[name]=test&items[0][name=x")
+	var o formOrder
+	require.NoError(t, UnmarshalArgs(args, &o, "url"))
+	require.Equal(t, "test", o.User.Name)
+	require.Empty(t, o.Items)
+
+	err := UnmarshalArgsWith(args, &formOrder{}, "url", strict)
+	require.EqualError(t, err, "failed to decode args, malformed key: items[0][name")
+
+	// Conflicting keys.
+	args.Parse("note=x&note[a]=y")
+	require.NoError(t, UnmarshalArgs(args, &formOrder{}, "url"))
+	err = UnmarshalArgsWith(args, &formOrder{}, "url", strict)
+	require.EqualError(t, err, "failed to decode args, conflicting values for key: note")
+
+	// Well formed args decode the same.
+	args.Parse("user[name]=test&items[0][qty]=1")
+	o = formOrder{}
+	require.NoError(t, UnmarshalArgsWith(args, &o, "url", strict))
+	require.Equal(t, []formItem{{Qty: 1}}, o.Items)
+}
+
+func TestDecodeForm(t *testing.T) {
+	g := NewGlue()
+	g.POST("/orders", func(r *Request) error {
+		var o formOrder
+		if err := r.DecodeForm(&o, "url"); err != nil {
+			return r.SendErrorEnvelope(fasthttp.StatusBadRequest, err.Error(), nil, excepBadRequest)
+		}
+		return r.SendEnvelope(o.Items)
+	})
+
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Request.Header.SetMethod(fasthttp.MethodPost)
+	ctx.Request.Header.SetContentType("application/x-www-form-urlencoded")
+	ctx.Request.SetRequestURI("/orders")
+	ctx.Request.SetBodyString(url.Values{
+		"items[0][name]": {"x"},
+		"items[0][qty]":  {"1"},
+		"items[1][name]": {"y"},
+		"items[1][qty]":  {"2"},
+	}.Encode())
+	g.Handler()(ctx)
+
+	require.Equal(t, fasthttp.StatusOK, ctx.Response.StatusCode())
+	require.JSONEq(t, `{"status": "success", "data": [{"Name": "x", "Qty": 1}, {"Name": "y", "Qty": 2}]}`, string(ctx.Response.Body()))
+}
@@ -0,0 +1,203 @@
+package fastglue
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+const (
+	excepAuth       = "AuthException"
+	excepPermission = "PermissionException"
+)
+
+// SignatureOptions represents the options for the VerifySignature middleware.
+type SignatureOptions struct {
+	// Header is the request header that carries the hex encoded signature.
+	// Defaults to X-Signature.
+	Header string
+
+	// Prefix is an optional prefix on the signature value, eg: `sha256=`.
+	Prefix string
+
+	// Algorithm returns the hash used for the HMAC. Defaults to sha256.New.
+	Algorithm func() hash.Hash
+
+	// Secret returns the signing secret for a request, for instance,
+	// by looking up the provider from a header or path param.
+	Secret func(r *Request) ([]byte, error)
+
+	// TimestampHeader is the optional request header that carries the Unix timestamp
+	// at which the payload was signed. If set, the signature is computed over
+	// `timestamp.body` instead of the body.
+	TimestampHeader string
+
+	// Tolerance is the maximum age of a signed timestamp to prevent replays.
+	// It's only applied when TimestampHeader is set.
+	Tolerance time.Duration
+}
+
+// VerifySignature is an (opinionated) middleware that verifies the HMAC signature
+// of the request body (eg: webhooks) as per the given options. If the signature is
+// missing or invalid, it fails the request with a 401 error envelope.
+// It should be registered with Before().
+func VerifySignature(o SignatureOptions) FastMiddleware {
+	if o.Header == "" {
+		o.Header = "X-Signature"
+	}
+	if o.Algorithm == nil {
+		o.Algorithm = sha256.New
+	}
+
+	return func(r *Request) *Request {
+		fail := func(msg string) *Request {
+			_ = r.SendErrorEnvelope(fasthttp.StatusUnauthorized, msg, nil, excepAuth)
+			return nil
+		}
+
+		if o.Secret == nil {
+			return fail("Invalid signature")
+		}
+		secret, err := o.Secret(r)
+		if err != nil || len(secret) == 0 {
+			return fail("Invalid signature")
+		}
+
+		sig := string(r.RequestCtx.Request.Header.Peek(o.Header))
+		if !strings.HasPrefix(sig, o.Prefix) {
+			return fail("Invalid signature")
+		}
+		got, err := hex.DecodeString(strings.TrimPrefix(sig, o.Prefix))
+		if err != nil || len(got) == 0 {
+			return fail("Invalid signature")
+		}
+
+		mac := hmac.New(o.Algorithm, secret)
+
+		// If there's a timestamp, validate it and sign it along with the body.
+		if o.TimestampHeader != "" {
+			ts := string(r.RequestCtx.Request.Header.Peek(o.TimestampHeader))
+			t, err := strconv.ParseInt(ts, 10, 64)
+			if err != nil {
+				return fail("Invalid signature timestamp")
+			}
+
+			if o.Tolerance > 0 {
+				d := time.Since(time.Unix(t, 0))
+				if d < 0 {
+					d = -d
+				}
+				if d > o.Tolerance {
+					return fail("Signature timestamp expired")
+				}
+			}
+
+			mac.Write([]byte(ts + "."))
+		}
+		mac.Write(r.RawBody())
+
+		if !hmac.Equal(got, mac.Sum(nil)) {
+			return fail("Invalid signature")
+		}
+
+		return r
+	}
+}
+
+// APIKeyOptions represents the options for the APIKeyAuth middleware.
+// The key is looked up in the Header, Query param and Cookie, in that order,
+// whichever are set.
+type APIKeyOptions struct {
+	// Header is the request header that carries the key, eg: X-API-Key.
+	Header string
+
+	// Query is the query param that carries the key, eg: api_key.
+	Query string
+
+	// Cookie is the cookie that carries the key.
+	Cookie string
+
+	// Validate validates a key and returns the principal (eg: user, app)
+	// that it belongs to.
+	Validate func(key string) (interface{}, bool)
+
+	// UserValueKey is the RequestCtx user value key with which the principal
+	// returned by Validate is set on the request. Defaults to `api_key`.
+	UserValueKey string
+}
+
+// APIKeyAuth is an (opinionated) middleware that authenticates requests with an API key
+// as per the given options. On success, the principal returned by the validator is set
+// as a user value on the request. If the key is missing or invalid, it fails the request
+// with a 401 error envelope. It should be registered with Before().
+func APIKeyAuth(o APIKeyOptions) FastMiddleware {
+	if o.UserValueKey == "" {
+		o.UserValueKey = "api_key"
+	}
+
+	return func(r *Request) *Request {
+		var key []byte
+		if o.Header != "" {
+			key = r.RequestCtx.Request.Header.Peek(o.Header)
+		}
+		if len(key) == 0 && o.Query != "" {
+			key = r.RequestCtx.QueryArgs().Peek(o.Query)
+		}
+		if len(key) == 0 && o.Cookie != "" {
+			key = r.RequestCtx.Request.Header.Cookie(o.Cookie)
+		}
+
+		if len(key) == 0 || o.Validate == nil {
+			_ = r.SendErrorEnvelope(fasthttp.StatusUnauthorized, "Missing API key", nil, excepAuth)
+			return nil
+		}
+
+		p, ok := o.Validate(string(key))
+		if !ok {
+			_ = r.SendErrorEnvelope(fasthttp.StatusUnauthorized, "Invalid API key", nil, excepAuth)
+			return nil
+		}
+
+		r.RequestCtx.SetUserValue(o.UserValueKey, p)
+		return r
+	}
+}
+
+// IPFilterOptions represents the options for the IPFilter middleware.
+type IPFilterOptions struct {
+	// Allow is the list of networks that are allowed. If it's empty,
+	// all addresses that aren't denied are allowed.
+	Allow []net.IPNet
+
+	// Deny is the list of networks that are denied. It takes
+	// precedence over Allow.
+	Deny []net.IPNet
+
+	// TrustedProxies is the list of proxies whose forwarded headers are
+	// trusted for resolving the client's IP. See Request.ClientIP.
+	TrustedProxies []net.IPNet
+}
+
+// IPFilter is an (opinionated) middleware that restricts requests by the client's
+// IP address as per the given allow and deny lists. Requests from addresses that
+// are denied, or aren't allowed, are failed with a 403 error envelope.
+// It should be registered with Before().
+func IPFilter(o IPFilterOptions) FastMiddleware {
+	return func(r *Request) *Request {
+		ip := net.ParseIP(r.ClientIP(o.TrustedProxies))
+
+		if ip == nil || isTrustedIP(ip, o.Deny) || (len(o.Allow) > 0 && !isTrustedIP(ip, o.Allow)) {
+			_ = r.SendErrorEnvelope(fasthttp.StatusForbidden, "Access denied", nil, excepPermission)
+			return nil
+		}
+
+		return r
+	}
+}
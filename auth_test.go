@@ -0,0 +1,157 @@
+package fastglue
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/valyala/fasthttp"
+)
+
+func TestVerifySignature(t *testing.T) {
+	secret := []byte("secret")
+	sign := func(b []byte) string {
+		h := hmac.New(sha256.New, secret)
+		h.Write(b)
+		return hex.EncodeToString(h.Sum(nil))
+	}
+
+	g := NewGlue()
+	g.Before(VerifySignature(SignatureOptions{
+		Header:          "X-Hub-Signature",
+		Prefix:          "sha256=",
+		TimestampHeader: "X-Timestamp",
+		Tolerance:       time.Minute,
+		Secret: func(r *Request) ([]byte, error) {
+			return secret, nil
+		},
+	}))
+	g.POST("/webhook", func(r *Request) error {
+		return r.SendEnvelope(true)
+	})
+
+	var (
+		body  = []byte(`{"event": "order.placed"}`)
+		now   = strconv.FormatInt(time.Now().Unix(), 10)
+		stale = strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10)
+	)
+	for _, c := range []struct {
+		name string
+		ts   string
+		sig  string
+		body []byte
+		code int
+	}{
+		{"valid", now, "sha256=" + sign([]byte(now+"."+string(body))), body, fasthttp.StatusOK},
+		{"tampered", now, "sha256=" + sign([]byte(now+"."+string(body))), []byte(`{"event": "order.cancelled"}`), fasthttp.StatusUnauthorized},
+		{"no prefix", now, sign([]byte(now + "." + string(body))), body, fasthttp.StatusUnauthorized},
+		{"replayed", stale, "sha256=" + sign([]byte(stale+"."+string(body))), body, fasthttp.StatusUnauthorized},
+		{"missing", now, "", body, fasthttp.StatusUnauthorized},
+	} {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.Header.SetMethod(fasthttp.MethodPost)
+		ctx.Request.SetRequestURI("/webhook")
+		ctx.Request.Header.Set("X-Timestamp", c.ts)
+		ctx.Request.Header.Set("X-Hub-Signature", c.sig)
+		ctx.Request.SetBody(c.body)
+		g.Handler()(ctx)
+		require.Equal(t, c.code, ctx.Response.StatusCode(), c.name)
+	}
+}
+
+func TestAPIKeyAuth(t *testing.T) {
+	g := NewGlue()
+	g.Before(APIKeyAuth(APIKeyOptions{
+		Header: "X-API-Key",
+		Query:  "api_key",
+		Cookie: "api_key",
+		Validate: func(key string) (interface{}, bool) {
+			if key == "valid" {
+				return "app1", true
+			}
+			return nil, false
+		},
+	}))
+	g.GET("/", func(r *Request) error {
+		return r.SendString(fasthttp.StatusOK, r.RequestCtx.UserValue("api_key").(string))
+	})
+
+	for _, c := range []struct {
+		name   string
+		uri    string
+		header string
+		cookie string
+		code   int
+	}{
+		{"header", "/", "valid", "", fasthttp.StatusOK},
+		{"query", "/?api_key=valid", "", "", fasthttp.StatusOK},
+		{"cookie", "/", "", "valid", fasthttp.StatusOK},
+		{"invalid", "/?api_key=invalid", "", "", fasthttp.StatusUnauthorized},
+		{"missing", "/", "", "", fasthttp.StatusUnauthorized},
+	} {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.SetRequestURI(c.uri)
+		if c.header != "" {
+			ctx.Request.Header.Set("X-API-Key", c.header)
+		}
+		if c.cookie != "" {
+			ctx.Request.Header.SetCookie("api_key", c.cookie)
+		}
+		g.Handler()(ctx)
+		require.Equal(t, c.code, ctx.Response.StatusCode(), c.name)
+		if c.code == fasthttp.StatusOK {
+			require.Equal(t, "app1", string(ctx.Response.Body()), c.name)
+		}
+	}
+}
+
+func TestIPFilter(t *testing.T) {
+	cidr := func(s string) net.IPNet {
+		_, n, err := net.ParseCIDR(s)
+		require.NoError(t, err)
+		return *n
+	}
+
+	g := NewGlue()
+	g.Before(IPFilter(IPFilterOptions{
+		Allow:          []net.IPNet{cidr("10.0.0.0/8")},
+		Deny:           []net.IPNet{cidr("10.0.0.66/32")},
+		TrustedProxies: []net.IPNet{cidr("192.168.1.1/32")},
+	}))
+	g.GET("/admin", func(r *Request) error {
+		return r.SendString(fasthttp.StatusOK, "ok")
+	})
+
+	for _, c := range []struct {
+		name   string
+		remote string
+		xff    string
+		code   int
+	}{
+		{"allowed", "10.1.2.3", "", fasthttp.StatusOK},
+		{"denied", "10.0.0.66", "", fasthttp.StatusForbidden},
+		{"not allowed", "172.16.0.1", "", fasthttp.StatusForbidden},
+		{"allowed via proxy", "192.168.1.1", "10.1.2.3", fasthttp.StatusOK},
+		{"denied via proxy", "192.168.1.1", "10.0.0.66", fasthttp.StatusForbidden},
+		{"untrusted proxy", "172.16.0.1", "10.1.2.3", fasthttp.StatusForbidden},
+	} {
+		var req fasthttp.Request
+		req.SetRequestURI("/admin")
+		if c.xff != "" {
+			req.Header.Set("X-Forwarded-For", c.xff)
+		}
+
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Init(&req, &net.TCPAddr{IP: net.ParseIP(c.remote)}, nil)
+		g.Handler()(ctx)
+		require.Equal(t, c.code, ctx.Response.StatusCode(), c.name)
+		if c.code == fasthttp.StatusForbidden {
+			require.Contains(t, string(ctx.Response.Body()), `"error_type":"PermissionException"`, c.name)
+		}
+	}
+}
@@ -0,0 +1,11 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd && !dragonfly
+// +build !linux,!darwin,!freebsd,!netbsd,!openbsd,!dragonfly
+
+package fastglue
+
+import "net"
+
+// isConnClosed can't determine the state of connections on this platform.
+func isConnClosed(c net.Conn) (closed bool, ok bool) {
+	return false, false
+}
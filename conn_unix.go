@@ -0,0 +1,49 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+// +build linux darwin freebsd netbsd openbsd dragonfly
+
+package fastglue
+
+import (
+	"net"
+	"syscall"
+)
+
+// isConnClosed checks whether the peer has closed the connection by peeking
+// into the socket without consuming any data. ok is false if the state of the
+// connection can't be determined.
+func isConnClosed(c net.Conn) (closed bool, ok bool) {
+	// Unwrap TLS connections.
+	if nc, ok := c.(interface{ NetConn() net.Conn }); ok {
+		c = nc.NetConn()
+	}
+
+	sc, ok := c.(syscall.Conn)
+	if !ok {
+		return false, false
+	}
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		return false, false
+	}
+
+	var b [1]byte
+	err = rc.Read(func(fd uintptr) bool {
+		n, _, err := syscall.Recvfrom(int(fd), b[:], syscall.MSG_PEEK|syscall.MSG_DONTWAIT)
+		switch {
+		case n == 0 && err == nil:
+			// EOF.
+			closed = true
+		case err != nil && err != syscall.EAGAIN && err != syscall.EWOULDBLOCK && err != syscall.EINTR:
+			closed = true
+		}
+
+		// Always return true so that the poller doesn't wait for the socket to be readable.
+		return true
+	})
+	if err != nil {
+		// The connection has been closed on this end.
+		return true, true
+	}
+
+	return closed, true
+}
@@ -2,7 +2,11 @@ package fastglue
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
+	"reflect"
+	"strings"
 
 	fasthttprouter "github.com/fasthttp/router"
 	"github.com/valyala/fasthttp"
@@ -14,6 +18,17 @@ const (
 
 	excepBadRequest = "InputException"
 	excepGeneral    = "GeneralException"
+
+	// defaultMaxBatchSize is the default max number of sub-requests in a batch request.
+	defaultMaxBatchSize = 100
+)
+
+var (
+	// fallbackErrorEnvelope is sent when an error envelope can't be marshalled.
+	fallbackErrorEnvelope = []byte(`{"status":"error","message":"Internal server error","error_type":"` + excepGeneral + `","data":null}`)
+
+	// tooLargeErrorEnvelope is sent when a response exceeds the max response size.
+	tooLargeErrorEnvelope = []byte(`{"status":"error","message":"Response too large","error_type":"` + excepGeneral + `","data":null}`)
 )
 
 // ErrorType defines string error constants (eg: TokenException)
@@ -29,24 +44,77 @@ type Envelope struct {
 	ErrorType *ErrorType  `json:"error_type,omitempty"`
 }
 
+// HTTPError is an error that can be returned by handlers to have the
+// error handler render it as an error envelope with the given status code.
+type HTTPError struct {
+	Code      int
+	Message   string
+	ErrorType ErrorType
+	Data      interface{}
+}
+
+// Error returns the error message.
+func (e *HTTPError) Error() string {
+	return e.Message
+}
+
+// BatchRequest represents a single sub-request in a batch request.
+type BatchRequest struct {
+	Method string          `json:"method"`
+	Path   string          `json:"path"`
+	Body   json.RawMessage `json:"body,omitempty"`
+}
+
+// BatchResponse represents the response of a single sub-request in a batch request.
+type BatchResponse struct {
+	Status int             `json:"status"`
+	Body   json.RawMessage `json:"body"`
+}
+
 // NewGlue creates and returns a new instance of Fastglue with custom error
 // handlers pre-bound.
 func NewGlue() *Fastglue {
 	f := New()
-	f.Router.MethodNotAllowed = BadMethodHandler
-	f.Router.NotFound = NotFoundHandler
+	f.Router.MethodNotAllowed = f.bareHandler(badMethod)
+	f.Router.NotFound = f.bareHandler(notFound)
 	f.Router.SaveMatchedRoutePath = true
 	f.MatchedRoutePathParam = fasthttprouter.MatchedRoutePathParam
 	return f
 }
 
 // DecodeFail uses Decode() to unmarshal the Post body, but in addition to returning
-// an error on failure, writes the error to the HTTP response directly. This helps
-// avoid repeating read/parse/validate boilerplate inside every single HTTP handler.
+// an error on failure, writes the error (with a DecodeError as data for JSON bodies)
+// to the HTTP response directly. This helps avoid repeating read/parse/validate
+// boilerplate inside every single HTTP handler.
 func (r *Request) DecodeFail(v interface{}, tag string) error {
+	return r.DecodeFailWith(v, tag, excepBadRequest, "Error unmarshalling request")
+}
+
+// UserValueDecodeError is the RequestCtx user value key with which DecodeFail and
+// DefaultErrorHandler set the original decoding error on a request so that middleware
+// (eg: an After() logger) can log it, eg: r.RequestCtx.UserValue(fastglue.UserValueDecodeError).
+const UserValueDecodeError = "decode_error"
+
+// DecodeFailWith is the same as DecodeFail but writes the failure envelope with the
+// given error type and message, which is prefixed to the decoding error.
+// An empty message defaults to DecodeFail's message. For JSON bodies, the envelope's
+// data is the DecodeError with the field and offset at which decoding failed.
+func (r *Request) DecodeFailWith(v interface{}, tag string, et ErrorType, msg string) error {
+	if msg == "" {
+		msg = "Error unmarshalling request"
+	}
+
 	if err := r.Decode(v, tag); err != nil {
+		r.RequestCtx.SetUserValue(UserValueDecodeError, err)
+
+		// Send the details of JSON decoding errors (field, offset) as data.
+		var data interface{}
+		if de := (*DecodeError)(nil); errors.As(err, &de) {
+			data = de
+		}
+
 		if errSend := r.SendErrorEnvelope(fasthttp.StatusBadRequest,
-			"Error unmarshalling request: `"+err.Error()+"`", nil, excepBadRequest); errSend != nil {
+			msg+": `"+err.Error()+"`", data, et); errSend != nil {
 			return errSend
 		}
 
@@ -59,11 +127,17 @@ func (r *Request) DecodeFail(v interface{}, tag string) error {
 // SendEnvelope is a highly opinionated method that sends success responses in a predefined
 // structure which has become customary at Rainmatter internally.
 func (r *Request) SendEnvelope(data interface{}) error {
+	return r.SendEnvelopeCode(fasthttp.StatusOK, data)
+}
+
+// SendEnvelopeCode is the same as SendEnvelope but sends the success envelope with
+// the given HTTP status code, for instance, 201 for created resources.
+func (r *Request) SendEnvelopeCode(code int, data interface{}) error {
 	// If data is json.RawMessage, we're getting a pre-formatted JSON byte array.
 	// Skip the marshaller, fake the envelope and send it right away.
 	if j, ok := data.(json.RawMessage); ok {
-		r.RequestCtx.SetStatusCode(fasthttp.StatusOK)
-		r.RequestCtx.SetContentType(JSON)
+		r.RequestCtx.SetStatusCode(code)
+		r.RequestCtx.SetContentType(r.jsonType())
 
 		if _, err := r.RequestCtx.Write([]byte(`{"status": "` + statusSuccess + `", "data": `)); err != nil {
 			return err
@@ -84,7 +158,27 @@ func (r *Request) SendEnvelope(data interface{}) error {
 		Data:   data,
 	}
 
-	if err := r.SendJSON(fasthttp.StatusOK, e); err != nil {
+	// Encode the envelope directly into the response body.
+	if r.glue != nil && r.glue.streamEnvelopes {
+		r.RequestCtx.SetStatusCode(code)
+		r.RequestCtx.SetContentType(r.jsonType())
+
+		if err := json.NewEncoder(r.RequestCtx).Encode(e); err != nil {
+			r.RequestCtx.Response.ResetBody()
+			return r.SendErrorEnvelope(fasthttp.StatusInternalServerError, "Couldn't marshal JSON: `"+err.Error()+"`", nil, excepGeneral)
+		}
+		if n := len(r.RequestCtx.Response.Body()); r.isTooLarge(n) {
+			return r.sendTooLarge(n)
+		}
+
+		return nil
+	}
+
+	if err := r.SendJSON(code, e); err != nil {
+		// The error response has already been sent.
+		if errors.Is(err, ErrResponseTooLarge) {
+			return err
+		}
 		return r.SendErrorEnvelope(fasthttp.StatusInternalServerError, "Couldn't marshal JSON: `"+err.Error()+"`", nil, excepGeneral)
 	}
 
@@ -93,7 +187,12 @@ func (r *Request) SendEnvelope(data interface{}) error {
 
 // SendErrorEnvelope is a highly opinionated method that sends error responses in a predefined
 // structure which has become customary at Rainmatter internally.
+//
+// If a MessageTranslator is set on the Fastglue instance, message is treated as a key and
+// translated to the language in the request's Accept-Language header.
 func (r *Request) SendErrorEnvelope(code int, message string, data interface{}, et ErrorType) error {
+	message = r.translate(message)
+
 	var e Envelope
 	if et == "" {
 		e = Envelope{
@@ -110,7 +209,140 @@ func (r *Request) SendErrorEnvelope(code int, message string, data interface{},
 		}
 	}
 
-	return r.SendJSON(code, e)
+	// If the envelope itself can't be marshalled (eg: data is unmarshallable),
+	// send a minimal, hardcoded error so that the client always gets valid JSON.
+	if err := r.SendJSON(code, e); err != nil {
+		// The too large error response has already been sent.
+		if errors.Is(err, ErrResponseTooLarge) {
+			return err
+		}
+
+		log.Printf("error marshalling error envelope: %v", err)
+		r.RequestCtx.Response.ResetBody()
+		return r.SendBytes(fasthttp.StatusInternalServerError, JSON, fallbackErrorEnvelope)
+	}
+
+	return nil
+}
+
+// isTooLarge checks whether a response of n bytes exceeds the max response size.
+func (r *Request) isTooLarge(n int) bool {
+	return r.glue != nil && r.glue.maxResponseSize > 0 && n > r.glue.maxResponseSize
+}
+
+// sendTooLarge discards the response, logs it, and sends a hardcoded error
+// envelope (which doesn't go through SendJSON and its size check).
+func (r *Request) sendTooLarge(n int) error {
+	log.Printf("response of %d bytes to %s %s exceeds the max response size of %d bytes",
+		n, r.RequestCtx.Method(), r.RequestCtx.Path(), r.glue.maxResponseSize)
+
+	r.RequestCtx.Response.ResetBody()
+	if err := r.SendBytes(fasthttp.StatusInternalServerError, JSON, tooLargeErrorEnvelope); err != nil {
+		return err
+	}
+	return ErrResponseTooLarge
+}
+
+// BatchHandler registers a POST handler on the given path that accepts a JSON array
+// of sub-requests (BatchRequest) and dispatches each of them in-process through the
+// router, running the normal routing and middleware. The responses of the
+// sub-requests are collected (BatchResponse) and sent as an array in a success envelope.
+// Sub-requests inherit the headers (eg: Authorization) of the batch request, and
+// are handled like any other request (maintenance mode, host routing, response
+// hooks etc.). Batches with more sub-requests than the limit set with
+// SetMaxBatchSize are rejected.
+func (f *Fastglue) BatchHandler(path string) {
+	f.POST(path, func(r *Request) error {
+		body, err := r.Body()
+		if err != nil {
+			return r.SendErrorEnvelope(fasthttp.StatusBadRequest, err.Error(), nil, excepBadRequest)
+		}
+
+		var reqs []BatchRequest
+		if err := json.Unmarshal(body, &reqs); err != nil {
+			return r.SendErrorEnvelope(fasthttp.StatusBadRequest,
+				"Error unmarshalling batch request: `"+err.Error()+"`", nil, excepBadRequest)
+		}
+		if f.maxBatchSize > 0 && len(reqs) > f.maxBatchSize {
+			return r.SendErrorEnvelope(fasthttp.StatusBadRequest,
+				fmt.Sprintf("Batch request exceeds the max of %d requests", f.maxBatchSize), nil, excepBadRequest)
+		}
+
+		out := make([]BatchResponse, 0, len(reqs))
+		for _, b := range reqs {
+			// Disallow recursive batch requests.
+			p := strings.SplitN(b.Path, "?", 2)[0]
+			if b.Method == "" || b.Path == "" || p == path || (f.caseInsensitive && strings.EqualFold(p, path)) {
+				out = append(out, BatchResponse{
+					Status: fasthttp.StatusBadRequest,
+					Body:   batchErrBody("Invalid method or path in batch request"),
+				})
+				continue
+			}
+
+			out = append(out, f.dispatchBatch(r, b))
+		}
+
+		return r.SendEnvelope(out)
+	})
+}
+
+// dispatchBatch runs a single sub-request through fastglue's handler with a
+// synthetic request context and returns its response.
+func (f *Fastglue) dispatchBatch(r *Request, b BatchRequest) BatchResponse {
+	var req fasthttp.Request
+	r.RequestCtx.Request.Header.CopyTo(&req.Header)
+	req.Header.SetMethod(b.Method)
+	req.SetRequestURI(b.Path)
+	req.Header.Del("Content-Length")
+	req.Header.Del("Content-Encoding")
+	req.SetBody(nil)
+	if len(b.Body) > 0 {
+		req.Header.SetContentType(JSON)
+		req.SetBody(b.Body)
+	}
+
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Init(&req, r.RequestCtx.RemoteAddr(), nil)
+
+	// As the sub-request isn't served by fasthttp, the user values it sets that have
+	// to be closed once it's done (eg: Concurrency slots) are closed here.
+	defer func() {
+		ctx.ResetUserValues()
+		ctx.Request.Reset()
+		ctx.Response.Reset()
+	}()
+	f.Handler()(ctx)
+
+	// Embed JSON responses as is and others as JSON strings.
+	body := ctx.Response.Body()
+	if !json.Valid(body) {
+		body, _ = json.Marshal(string(body))
+	} else {
+		body = append([]byte(nil), body...)
+	}
+
+	return BatchResponse{
+		Status: ctx.Response.StatusCode(),
+		Body:   body,
+	}
+}
+
+func batchErrBody(msg string) json.RawMessage {
+	b, _ := json.Marshal(Envelope{Status: statusError, Message: &msg})
+	return b
+}
+
+// SendFieldErrorsEnvelope is a highly opinionated method that sends a 400 error envelope
+// with the given message and the errors of each field in FieldErrors as a
+// map of field names to error messages in `data`.
+func (r *Request) SendFieldErrorsEnvelope(message string, fe FieldErrors) error {
+	data := make(map[string]string, len(fe))
+	for k, err := range fe {
+		data[k] = err.Error()
+	}
+
+	return r.SendErrorEnvelope(fasthttp.StatusBadRequest, message, data, excepBadRequest)
 }
 
 // ReqParams is an (opinionated) middleware that checks if a given set of parameters are set in
@@ -188,20 +420,106 @@ func ReqLenRangeParams(h FastRequestHandler, fields map[string][2]int) FastReque
 	}
 }
 
-// NotFoundHandler produces an enveloped JSON response for 404 errors.
-func NotFoundHandler(r *fasthttp.RequestCtx) {
-	req := &Request{
-		RequestCtx: r,
+// ReqMaxBodySize is an (opinionated) middleware that checks if the size of the request body
+// is within the given number of bytes. If not, it fails the request with an error envelope.
+// The Content-Length header is checked first so that the body isn't read where possible.
+func ReqMaxBodySize(h FastRequestHandler, size int) FastRequestHandler {
+	return func(r *Request) error {
+		if r.RequestCtx.Request.Header.ContentLength() > size || len(r.RequestCtx.Request.Body()) > size {
+			_ = r.SendErrorEnvelope(fasthttp.StatusRequestEntityTooLarge, "Request body too large", nil, excepBadRequest)
+			return nil
+		}
+
+		return h(r)
+	}
+}
+
+// BindParams is an (opinionated) middleware that scans the GET or POST params into a new
+// value of the type of v (a struct or a pointer to one) with ScanArgsAll. If any of the
+// params are invalid, it fails the request with an error envelope with the errors of
+// every field. Otherwise, a pointer to the scanned value is set on the request and can
+// be retrieved in the handler with BoundParams().
+func BindParams(h FastRequestHandler, v interface{}, tag string) FastRequestHandler {
+	t := reflect.TypeOf(v)
+	if t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		panic(fmt.Sprintf("BindParams needs a struct or a pointer to one, got %T", v))
+	}
+
+	return func(r *Request) error {
+		var args *fasthttp.Args
+
+		if r.RequestCtx.IsPost() || r.RequestCtx.IsPut() {
+			args = r.RequestCtx.PostArgs()
+		} else {
+			args = r.RequestCtx.QueryArgs()
+		}
+
+		p := reflect.New(t).Interface()
+		if _, err := ScanArgsAll(args, p, tag); err != nil {
+			if fe, ok := err.(FieldErrors); ok {
+				_ = r.SendFieldErrorsEnvelope("Invalid request params", fe)
+			} else {
+				_ = r.SendErrorEnvelope(fasthttp.StatusBadRequest, "Invalid request params", nil, excepBadRequest)
+			}
+			return nil
+		}
+
+		r.RequestCtx.SetUserValue(keyBoundParams, p)
+		return h(r)
 	}
+}
 
-	_ = req.SendErrorEnvelope(fasthttp.StatusNotFound, "Route not found", nil, excepGeneral)
+// BoundParams returns the pointer to the params scanned by the BindParams middleware,
+// eg: r.BoundParams().(*Query). It's nil if the handler isn't wrapped with BindParams.
+func (r *Request) BoundParams() interface{} {
+	return r.RequestCtx.UserValue(keyBoundParams)
+}
+
+// NotFoundHandler produces an enveloped JSON response for 404 errors.
+func NotFoundHandler(r *fasthttp.RequestCtx) {
+	_ = notFound(&Request{RequestCtx: r})
 }
 
 // BadMethodHandler produces an enveloped JSON response for 405 errors.
 func BadMethodHandler(r *fasthttp.RequestCtx) {
-	req := &Request{
-		RequestCtx: r,
+	_ = badMethod(&Request{RequestCtx: r})
+}
+
+// notFound and badMethod are the fastglue handler counterparts of NotFoundHandler
+// and BadMethodHandler that NewGlue registers so that 404 and 405 responses get
+// the Context (and the message translator) of the Fastglue instance.
+func notFound(r *Request) error {
+	return r.SendErrorEnvelope(fasthttp.StatusNotFound, "Route not found", nil, excepGeneral)
+}
+
+func badMethod(r *Request) error {
+	return r.SendErrorEnvelope(fasthttp.StatusMethodNotAllowed, "Request method not allowed", nil, excepGeneral)
+}
+
+// DefaultErrorHandler produces an enveloped JSON response when a handler returns
+// an error. *HTTPError is rendered with its code, message and error type, replacing
+// any body the handler has already written, and any other error results in a 500.
+// If the handler has already written a response (eg: DecodeFail) before returning
+// an unknown error, it's left untouched.
+// A *DecodeError is set on the request with the UserValueDecodeError key.
+func DefaultErrorHandler(r *Request, err error) {
+	if de := (*DecodeError)(nil); errors.As(err, &de) {
+		r.RequestCtx.SetUserValue(UserValueDecodeError, err)
+	}
+
+	var he *HTTPError
+	if errors.As(err, &he) {
+		r.RequestCtx.Response.ResetBody()
+		_ = r.SendErrorEnvelope(he.Code, he.Message, he.Data, he.ErrorType)
+		return
+	}
+
+	if isResponseWritten(r.RequestCtx) {
+		return
 	}
 
-	_ = req.SendErrorEnvelope(fasthttp.StatusMethodNotAllowed, "Request method not allowed", nil, excepGeneral)
+	_ = r.SendErrorEnvelope(fasthttp.StatusInternalServerError, "Internal server error", nil, excepGeneral)
 }
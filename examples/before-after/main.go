@@ -19,8 +19,8 @@ func main() {
 	flag.Parse()
 
 	g := fastglue.New()
-	g.Before(setTime)
-	g.After(calculateTime)
+	g.Before(logRequest)
+	g.After(logTime)
 	g.GET("/", handleIndex)
 
 	s := &fasthttp.Server{
@@ -40,18 +40,12 @@ func handleIndex(r *fastglue.Request) error {
 	return r.SendString(http.StatusOK, fmt.Sprintf("Hello %s!", name))
 }
 
-func setTime(r *fastglue.Request) *fastglue.Request {
-	r.RequestCtx.SetUserValue("now", time.Now())
+func logRequest(r *fastglue.Request) *fastglue.Request {
+	log.Print("request ", string(r.RequestCtx.Path()))
 	return r
 }
 
-func calculateTime(r *fastglue.Request) *fastglue.Request {
-	now, ok := r.RequestCtx.UserValue("now").(time.Time)
-	if !ok {
-		return r
-	}
-
-	log.Print("time taken", time.Since(now))
-
+func logTime(r *fastglue.Request) *fastglue.Request {
+	log.Print("time taken ", r.Elapsed())
 	return r
 }
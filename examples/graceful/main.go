@@ -4,7 +4,6 @@ import (
 	"flag"
 	"log"
 	"os"
-	"os/signal"
 	"syscall"
 	"time"
 
@@ -27,19 +26,8 @@ func main() {
 		ReadTimeout:  5 * time.Second,
 		WriteTimeout: 5 * time.Second,
 	}
-	var (
-		ch  = make(chan struct{}, 1)
-		sig = make(chan os.Signal, 1)
-	)
 
-	signal.Notify(sig, os.Interrupt, syscall.SIGALRM, syscall.SIGABRT)
-
-	go func() {
-		<-sig
-		ch <- struct{}{}
-	}()
-
-	if err := g.ListenServeAndWaitGracefully(*addr, "", s, ch); err != nil {
+	if err := g.ListenAndServeGracefulSignals(*addr, "", s, os.Interrupt, syscall.SIGALRM, syscall.SIGABRT); err != nil {
 		log.Fatalf("Error in ListenAndServe: %s", err)
 	}
 }
@@ -48,8 +48,8 @@ func isAlphanumLen(input string) bool {
 
 func validate(h fastglue.FastRequestHandler) fastglue.FastRequestHandler {
 	return func(r *fastglue.Request) error {
-		a := string(r.RequestCtx.PostArgs().Peek("a"))
-		b := string(r.RequestCtx.PostArgs().Peek("b"))
+		a := r.FormString("a", "")
+		b := r.FormString("b", "")
 		if !isAlphanum(a) || !isAlphanum(b) {
 			return r.SendErrorEnvelope(http.StatusBadRequest, "validation failed", nil, "ValidationError")
 		}
@@ -76,8 +76,8 @@ func validateAll(h fastglue.FastRequestHandler) fastglue.FastRequestHandler {
 
 func handleMiddleware(r *fastglue.Request) error {
 	var out = map[string]interface{}{
-		"a": string(r.RequestCtx.PostArgs().Peek("a")),
-		"b": string(r.RequestCtx.PostArgs().Peek("b")),
+		"a": r.FormString("a", ""),
+		"b": r.FormString("b", ""),
 	}
 
 	return r.SendEnvelope(out)
@@ -33,9 +33,7 @@ func main() {
 }
 
 func handleIndex(r *fastglue.Request) error {
-	var (
-		name, _ = r.RequestCtx.UserValue("name").(string)
-	)
+	name := r.Param("name")
 
 	if name == "" {
 		name = "world!"
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/valyala/fasthttp"
+	"github.com/zerodha/fastglue"
+)
+
+var (
+	addr = flag.String("addr", ":8080", "TCP address to listen to")
+)
+
+func main() {
+	flag.Parse()
+
+	g := fastglue.New()
+	g.GET("/", func(r *fastglue.Request) error {
+		return r.SendString(http.StatusOK, "Hello from "+strconv.Itoa(os.Getpid())+"\n")
+	})
+
+	// Pick up the listener from the parent process if this is a reload.
+	ln, err := fastglue.InheritedListener()
+	if err != nil {
+		log.Fatalf("Error inheriting listener: %s", err)
+	}
+	if ln == nil {
+		if ln, err = net.Listen("tcp", *addr); err != nil {
+			log.Fatalf("Error listening: %s", err)
+		}
+	}
+
+	s := &fasthttp.Server{
+		Name:         "Reload",
+		ReadTimeout:  5 * time.Second,
+		WriteTimeout: 5 * time.Second,
+	}
+
+	log.Printf("Serving on %s (pid %d). Send SIGHUP to reload.", ln.Addr(), os.Getpid())
+	if err := g.ServeAndReload(ln, s); err != nil {
+		log.Fatalf("Error in ServeAndReload: %s", err)
+	}
+	log.Printf("Process %d exited after reload.", os.Getpid())
+}
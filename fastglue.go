@@ -1,14 +1,33 @@
 package fastglue
 
 import (
+	"bufio"
 	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"encoding/xml"
 	"errors"
 	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 
+	"github.com/andybalholm/brotli"
 	fasthttprouter "github.com/fasthttp/router"
 	"github.com/valyala/fasthttp"
 )
@@ -23,6 +42,9 @@ const (
 	// PLAINTEXT is an alias for the plaintext content type
 	PLAINTEXT = "text/plain"
 
+	// NDJSON is an alias for the newline delimited JSON content type
+	NDJSON = "application/x-ndjson"
+
 	// AuthBasic represents HTTP BasicAuth scheme.
 	AuthBasic = 1 << iota
 	// AuthToken represents the key:value Token auth scheme.
@@ -32,6 +54,42 @@ const (
 var (
 	constJSON = []byte("json")
 	constXML  = []byte("xml")
+	constForm = []byte("application/x-www-form-urlencoded")
+
+	// User value keys.
+	keyRawBody        = "__fastglue_raw_body__"
+	keyStaticFallback = "__fastglue_static_fallback__"
+	keyStdContext     = "__fastglue_std_context__"
+	keyMultipartForm  = "__fastglue_multipart_form__"
+	keyRouteOptions   = "__fastglue_route_options__"
+	keyBoundParams    = "__fastglue_bound_params__"
+	keyRawPath        = "__fastglue_raw_path__"
+	keyRequest        = "__fastglue_request__"
+	keyParamNames     = "__fastglue_param_names__"
+	keyHostParamNames = "__fastglue_host_param_names__"
+
+	// connCheckInterval is the interval at which the connection of a request
+	// is checked for closure to cancel its StdContext.
+	connCheckInterval = 100 * time.Millisecond
+
+	// defaultAnyMethods are the methods that Any() attaches handlers to by default.
+	defaultAnyMethods = []string{
+		fasthttp.MethodGet,
+		fasthttp.MethodPost,
+		fasthttp.MethodPut,
+		fasthttp.MethodDelete,
+	}
+
+	// signalNotify relays OS signals for ListenAndServeGracefulSignals.
+	// It's swapped in tests to send signals without signalling the process.
+	signalNotify = signal.Notify
+
+	// requestPool is the pool of Request wrappers that are injected into handlers.
+	requestPool = sync.Pool{
+		New: func() interface{} {
+			return &Request{}
+		},
+	}
 
 	// Authorization schemes.
 	authBasic = []byte("Basic")
@@ -46,33 +104,136 @@ type FastRequestHandler func(*Request) error
 // that can be registered using Before() and After() functions.
 type FastMiddleware func(*Request) *Request
 
+// FastMiddlewareFunc is a fastglue middleware handler function that returns
+// an error to stop the processing of a request. Returning ErrAbort signals an
+// explicit abort where the middleware has already written the response. Any other
+// error is treated as a failure and is passed to the error handler. It can be
+// registered using BeforeFunc(), AfterFunc() and FinallyFunc().
+type FastMiddlewareFunc func(*Request) error
+
+// ErrAbort is returned by a FastMiddlewareFunc to abort a request
+// after it has written a response.
+var ErrAbort = errors.New("request aborted by middleware")
+
+// FastErrorHandler is the fastglue error handler function that's invoked
+// when a FastRequestHandler returns a non-nil error. It can be registered
+// using SetErrorHandler() and is responsible for writing the error response.
+type FastErrorHandler func(*Request, error)
+
+// ResponseHook is a function that's invoked once for every response
+// that's written by the server, including 404, 405 and errors.
+// It receives the final status code and the size of the response body, which
+// for body streams is their Content-Length, or -1 if it's unknown (chunked).
+type ResponseHook func(r *Request, status int, bytes int)
+
 // Request is a wrapper over fasthttp's RequestCtx that's injected
 // into request handlers.
+//
+// Requests are pooled and reused once the handler (and middleware) returns.
+// Just like fasthttp's RequestCtx, a Request must not be retained or used
+// in goroutines that outlive the handler. Use Detach() for such cases.
 type Request struct {
 	RequestCtx *fasthttp.RequestCtx
 	Context    interface{}
+
+	// glue is the Fastglue instance that's handling the request.
+	glue *Fastglue
+
+	// handlerName is the function name of the route's handler.
+	handlerName string
+
+	// routeOptions are the options of the route, if it has any.
+	routeOptions *RouteOptions
+
+	// jsonContentType overrides the JSON content type of the responses.
+	jsonContentType string
 }
 
+// MessageTranslator is a function that translates a message key to the
+// given language (eg: en-US from the Accept-Language header). It should return an
+// empty string if there's no translation, in which case the key is used as is.
+type MessageTranslator func(lang, key string) string
+
 // Fastglue is the "glue" wrapper over fasthttp and fasthttprouter.
 type Fastglue struct {
 	Router                *fasthttprouter.Router
 	Server                *fasthttp.Server
 	context               interface{}
 	MatchedRoutePathParam string
-	before                []FastMiddleware
-	after                 []FastMiddleware
+
+	// MultipartMaxMemory is the maximum number of bytes of a multipart form's
+	// files that Request.MultipartForm keeps in memory. Files beyond it are
+	// spilled to temporary files on disk which are removed once the request is done.
+	// The size of the whole form (and hence, what can be spilled to disk) is
+	// bounded by the server's MaxRequestBodySize, which fasthttp enforces before
+	// the handler is invoked. If set, the server's DisablePreParseMultipartForm
+	// is turned on as fasthttp otherwise parses forms with its own limit.
+	MultipartMaxMemory int64
+
+	before                   []FastMiddlewareFunc
+	after                    []FastMiddlewareFunc
+	finally                  []FastMiddlewareFunc
+	onResponse               []ResponseHook
+	headers                  []func(r *Request)
+	transforms               []func(r *Request, body []byte) []byte
+	maintenance              atomic.Value
+	serverConfig             []func(*fasthttp.Server)
+	caseInsensitive          bool
+	maxResponseSize          int
+	maxBatchSize             int
+	onStop                   []func() error
+	methodOverride           bool
+	decoders                 map[string]func([]byte, interface{}) error
+	encoders                 map[string]func(interface{}) ([]byte, error)
+	routeOptions             *routeOptionsSet
+	optionServers            map[*fasthttp.Server]struct{}
+	hosts                    []*HostRouter
+	useNumber                bool
+	errHandler               FastErrorHandler
+	shutdownTimeout          time.Duration
+	shutdownProgress         func(active int)
+	shutdownProgressInterval time.Duration
+	conns                    *connTracker
+	translator               MessageTranslator
+	anyMethods               []string
+	streamEnvelopes          bool
 }
 
-// New creates and returns a new instance of Fastglue.
+// ErrShutdownTimeout is returned by graceful shutdown when active connections
+// don't finish within the shutdown timeout and are forcefully closed.
+var ErrShutdownTimeout = errors.New("shutdown timed out, closed active connections")
+
+// ErrEarlyHintsUnsupported is returned by SendEarlyHints when early
+// hints can't be written for the request.
+var ErrEarlyHintsUnsupported = errors.New("early hints are not supported for the request")
+
+// ErrResponseTooLarge is returned by SendJSON and SendEnvelope when the response
+// exceeds the limit set with SetMaxResponseSize.
+var ErrResponseTooLarge = errors.New("response exceeds the max response size")
+
+// ErrBodyTooLarge is returned by Body (and the Decode methods) when a compressed
+// request body exceeds the server's MaxRequestBodySize (or the route's
+// RouteOptions.MaxBodySize) once it's decompressed.
+var ErrBodyTooLarge = errors.New("decompressed body exceeds the max body size")
+
+// New creates and returns a new instance of Fastglue. Errors returned
+// by handlers are handled with DefaultErrorHandler.
 func New() *Fastglue {
 	return &Fastglue{
-		Router: fasthttprouter.New(),
+		Router:       fasthttprouter.New(),
+		errHandler:   DefaultErrorHandler,
+		maxBatchSize: defaultMaxBatchSize,
 	}
 }
 
 // ListenAndServe is a wrapper for fasthttp.ListenAndServe. It takes a TCP address,
 // an optional UNIX socket file path and starts listeners, and an optional fasthttp.Server.
 func (f *Fastglue) ListenAndServe(address string, socket string, s *fasthttp.Server) error {
+	return listenAndServe(address, socket, f.initServer(s))
+}
+
+// listenAndServe starts the (initialized) server on the TCP address or the UNIX socket.
+func listenAndServe(address string, socket string, s *fasthttp.Server) error {
 	if address == "" && socket == "" {
 		return errors.New("specify either a TCP address or a UNIX socket")
 	}
@@ -80,37 +241,231 @@ func (f *Fastglue) ListenAndServe(address string, socket string, s *fasthttp.Ser
 		return errors.New("specify either a TCP address or a UNIX socket, not both")
 	}
 
+	if socket != "" {
+		return s.ListenAndServeUNIX(socket, 0666)
+	}
+
+	return s.ListenAndServe(address)
+}
+
+// ListenAndServeTLS is a wrapper for fasthttp.ListenAndServeTLS. It takes a TCP address,
+// paths to the TLS certificate and key files, and an optional fasthttp.Server.
+func (f *Fastglue) ListenAndServeTLS(address, certFile, keyFile string, s *fasthttp.Server) error {
+	if address == "" {
+		return errors.New("specify a TCP address")
+	}
+	if certFile == "" || keyFile == "" {
+		return errors.New("specify both the TLS certificate and key files")
+	}
+
+	return f.initServer(s).ListenAndServeTLS(address, certFile, keyFile)
+}
+
+// ListenAndServeTLSEmbed is a wrapper for fasthttp.ListenAndServeTLSEmbed. It's the same
+// as ListenAndServeTLS but takes the TLS certificate and key as bytes instead of files.
+func (f *Fastglue) ListenAndServeTLSEmbed(address string, certData, keyData []byte, s *fasthttp.Server) error {
+	if address == "" {
+		return errors.New("specify a TCP address")
+	}
+	if len(certData) == 0 || len(keyData) == 0 {
+		return errors.New("specify both the TLS certificate and key")
+	}
+
+	return f.initServer(s).ListenAndServeTLSEmbed(address, certData, keyData)
+}
+
+// ListenOnRandomPort starts the server on a random free port on the loopback interface
+// and returns its address (eg: 127.0.0.1:43567) along with a function that gracefully
+// shuts down the server. This is useful for running integration tests concurrently
+// without port clashes.
+func (f *Fastglue) ListenOnRandomPort() (string, func(), error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", nil, err
+	}
+
+	var (
+		s    = f.initServer(nil)
+		done = make(chan struct{})
+	)
+	go func() {
+		_ = s.Serve(ln)
+		close(done)
+	}()
+
+	stop := func() {
+		_ = f.shutdown(s)
+		<-done
+	}
+	return ln.Addr().String(), stop, nil
+}
+
+// ConfigureServer registers functions that configure (eg: Concurrency, ReadBufferSize)
+// the fasthttp.Server when it's started with any of the Listen* or Serve* methods.
+// They're applied in order to the server that's passed to those methods or to the
+// default server that's created if none is passed, before the handler is attached.
+func (f *Fastglue) ConfigureServer(fn ...func(s *fasthttp.Server)) {
+	f.serverConfig = append(f.serverConfig, fn...)
+}
+
+// initServer creates a default fasthttp.Server if s is nil, wires the fastglue
+// handler to it if it doesn't have one, and sets it on the instance.
+func (f *Fastglue) initServer(s *fasthttp.Server) *fasthttp.Server {
 	// No server passed, create a default one.
 	if s == nil {
 		s = &fasthttp.Server{}
 	}
 	f.Server = s
 
+	for _, fn := range f.serverConfig {
+		fn(s)
+	}
+
 	if s.Handler == nil {
 		s.Handler = f.Handler()
 	}
 
-	if socket != "" {
-		return s.ListenAndServeUNIX(socket, 0666)
+	if f.hasRouteOptions() {
+		f.applyRouteOptions(s)
 	}
 
-	return s.ListenAndServe(address)
+	// Leave multipart forms to Request.MultipartForm.
+	if f.MultipartMaxMemory > 0 {
+		s.DisablePreParseMultipartForm = true
+	}
+
+	// Track open connections so that they can be closed
+	// if graceful shutdown times out.
+	if f.shutdownTimeout > 0 && f.conns == nil {
+		f.conns = &connTracker{conns: make(map[net.Conn]struct{})}
+		hook := s.ConnState
+		s.ConnState = func(c net.Conn, st fasthttp.ConnState) {
+			f.conns.track(c, st)
+			if hook != nil {
+				hook(c, st)
+			}
+		}
+	}
+
+	return s
+}
+
+// connTracker keeps track of open server connections.
+type connTracker struct {
+	mu    sync.Mutex
+	conns map[net.Conn]struct{}
+}
+
+func (t *connTracker) track(c net.Conn, st fasthttp.ConnState) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	switch st {
+	case fasthttp.StateNew:
+		t.conns[c] = struct{}{}
+	case fasthttp.StateHijacked, fasthttp.StateClosed:
+		delete(t.conns, c)
+	}
+}
+
+func (t *connTracker) closeAll() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for c := range t.conns {
+		_ = c.Close()
+		delete(t.conns, c)
+	}
 }
 
 // ListenServeAndWaitGracefully accepts the same parameters
 // as ListenAndServe along with a channel which can receive
 // a signal to shutdown the server.
 func (f *Fastglue) ListenServeAndWaitGracefully(address string, socket string, s *fasthttp.Server, shutdownServer chan struct{}) error {
-	errChan := make(chan error, 1)
+	s = f.initServer(s)
+	defer close(shutdownServer)
+	return f.serveGracefully(s, func() error {
+		return listenAndServe(address, socket, s)
+	}, shutdownServer)
+}
+
+// ListenAndServeGracefulSignals accepts the same parameters as ListenAndServe
+// along with the OS signals (SIGINT and SIGTERM if none are given) on which
+// the server is gracefully shut down. It blocks until the server is shut
+// down, which saves having to wire the signals with ListenServeAndWaitGracefully.
+func (f *Fastglue) ListenAndServeGracefulSignals(address string, socket string, s *fasthttp.Server, signals ...os.Signal) error {
+	if len(signals) == 0 {
+		signals = []os.Signal{os.Interrupt, syscall.SIGTERM}
+	}
+
+	sig := make(chan os.Signal, 1)
+	signalNotify(sig, signals...)
+	defer signal.Stop(sig)
+
+	var (
+		ch   = make(chan struct{}, 1)
+		done = make(chan struct{})
+	)
+	defer close(done)
+	go func() {
+		select {
+		case <-sig:
+			ch <- struct{}{}
+		case <-done:
+		}
+	}()
+
+	s = f.initServer(s)
+	return f.serveGracefully(s, func() error {
+		return listenAndServe(address, socket, s)
+	}, ch)
+}
+
+// Serve is a wrapper for fasthttp.Serve. It takes an already configured
+// net.Listener (eg: from systemd socket activation or a reuseport listener)
+// and an optional fasthttp.Server.
+func (f *Fastglue) Serve(ln net.Listener, s *fasthttp.Server) error {
+	return serve(ln, f.initServer(s))
+}
+
+// serve serves the (initialized) server on the listener.
+func serve(ln net.Listener, s *fasthttp.Server) error {
+	if ln == nil {
+		return errors.New("specify a listener")
+	}
+	return s.Serve(ln)
+}
+
+// ServeGracefully accepts the same parameters as Serve along with
+// a channel which can receive a signal to shutdown the server.
+func (f *Fastglue) ServeGracefully(ln net.Listener, s *fasthttp.Server, shutdownServer chan struct{}) error {
+	s = f.initServer(s)
+	defer close(shutdownServer)
+	return f.serveGracefully(s, func() error {
+		return serve(ln, s)
+	}, shutdownServer)
+}
+
+// serveGracefully runs the given serve function and blocks until either
+// it returns an error or a signal on shutdownServer shuts down the server.
+func (f *Fastglue) serveGracefully(s *fasthttp.Server, serve func() error, shutdownServer <-chan struct{}) error {
+	var (
+		errChan = make(chan error, 1)
+		done    = make(chan struct{})
+	)
+	defer close(done)
+
 	// Listen for signal on shutdownServer channel
 	go func() {
-		for range shutdownServer {
-			errChan <- s.Shutdown()
+		select {
+		case <-shutdownServer:
+			errChan <- f.shutdown(s)
+		case <-done:
 		}
 	}()
 	// Start the http server
 	go func() {
-		err := f.ListenAndServe(address, socket, s)
+		err := serve()
 		if err != nil {
 			// Only if the err was nil, we want to send to the errChan
 			// else we will keep waiting for shutdownServer to
@@ -120,12 +475,7 @@ func (f *Fastglue) ListenServeAndWaitGracefully(address string, socket string, s
 	}()
 
 	// Wait for an error/nil, till then keep running.
-	for err := range errChan {
-		close(shutdownServer)
-		return err
-	}
-
-	return nil
+	return <-errChan
 }
 
 // Shutdown gracefully shuts down the server without interrupting any active connections.
@@ -143,102 +493,581 @@ func (f *Fastglue) ListenServeAndWaitGracefully(address string, socket string, s
 //
 // Shutdown does not close keepalive connections so its recommended
 // to set ReadTimeout to something else than 0.
+//
+// If a shutdown timeout is set with SetShutdownTimeout(), active connections are
+// forcefully closed after the timeout and ErrShutdownTimeout is returned.
 func (f *Fastglue) Shutdown(s *fasthttp.Server, shutdownComplete chan error) {
-	shutdownComplete <- f.Server.Shutdown()
+	shutdownComplete <- f.shutdown(f.Server)
+}
+
+// SetShutdownTimeout sets the maximum duration graceful shutdown waits for
+// active connections to finish, after which they're forcefully closed.
+// It should be set before the server is started. 0 (default) waits indefinitely.
+func (f *Fastglue) SetShutdownTimeout(d time.Duration) {
+	f.shutdownTimeout = d
+}
+
+// OnShutdownProgress registers a callback that's invoked every `interval` while
+// graceful shutdown waits for connections to drain, with the number of connections
+// that are still open. This is useful for deciding when to forcefully kill the process.
+func (f *Fastglue) OnShutdownProgress(fn func(active int), interval time.Duration) {
+	if interval <= 0 {
+		interval = time.Second
+	}
+	f.shutdownProgress = fn
+	f.shutdownProgressInterval = interval
+}
+
+// OnStop registers hooks that are run in order once graceful shutdown has drained
+// the server's connections (or timed out), for cleanups such as flushing logs and
+// closing DB connections. All hooks are run, and their errors, along with the
+// shutdown error, are returned together (wrapped like errors.Join) by the graceful
+// shutdown functions.
+func (f *Fastglue) OnStop(fn ...func() error) {
+	f.onStop = append(f.onStop, fn...)
+}
+
+// shutdown shuts down the server gracefully and runs the OnStop hooks.
+func (f *Fastglue) shutdown(s *fasthttp.Server) error {
+	errs := []error{f.drain(s)}
+	for _, fn := range f.onStop {
+		errs = append(errs, fn())
+	}
+	return joinErrors(errs...)
+}
+
+// drain shuts down the server gracefully, respecting the shutdown timeout.
+func (f *Fastglue) drain(s *fasthttp.Server) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- s.Shutdown()
+	}()
+
+	// Periodically report the number of connections that are draining.
+	var progress <-chan time.Time
+	if f.shutdownProgress != nil {
+		t := time.NewTicker(f.shutdownProgressInterval)
+		defer t.Stop()
+		progress = t.C
+	}
+
+	var timeout <-chan time.Time
+	if f.shutdownTimeout > 0 && f.conns != nil {
+		t := time.NewTimer(f.shutdownTimeout)
+		defer t.Stop()
+		timeout = t.C
+	}
+
+	for {
+		select {
+		case err := <-done:
+			return err
+		case <-progress:
+			f.shutdownProgress(int(s.GetOpenConnectionsCount()))
+		case <-timeout:
+			f.conns.closeAll()
+			return ErrShutdownTimeout
+		}
+	}
 }
 
 // handler is the "proxy" abstraction that converts a fastglue handler into
 // a fasthttp handler and passes execution in and out.
-func (f *Fastglue) handler(h FastRequestHandler) func(*fasthttp.RequestCtx) {
+func (f *Fastglue) handler(h FastRequestHandler, o ...RouteOptions) func(*fasthttp.RequestCtx) {
+	name := runtime.FuncForPC(reflect.ValueOf(h).Pointer()).Name()
+
+	var opt *RouteOptions
+	if len(o) > 0 {
+		opt = &o[0]
+	}
+
 	return func(ctx *fasthttp.RequestCtx) {
-		req := &Request{
-			RequestCtx: ctx,
-			Context:    f.context,
+		req, ok := ctx.UserValue(keyRequest).(*Request)
+		if !ok {
+			req = f.acquireRequest(ctx)
+			defer releaseRequest(req)
+		}
+		req.handlerName = name
+		req.routeOptions = opt
+
+		// Apply "finally" middleware irrespective of how the request ends.
+		if len(f.finally) > 0 {
+			defer f.applyMiddleware(req, f.finally)
 		}
 
 		// Apply "before" middleware.
-		for _, p := range f.before {
-			if p(req) == nil {
-				return
-			}
+		if !f.applyMiddleware(req, f.before) {
+			return
 		}
 
-		_ = h(req)
+		if err := h(req); err != nil && f.errHandler != nil {
+			f.errHandler(req, err)
+		}
 
 		// Apply "after" middleware.
-		for _, p := range f.after {
-			if p(req) == nil {
-				return
+		f.applyMiddleware(req, f.after)
+	}
+}
+
+// withPath wraps the handler of the route pattern `path` to set the names of its
+// path params (for Params()) on the request, and to restore their original values
+// when the request path was lowercased for routing.
+func (f *Fastglue) withPath(path string, fh fasthttp.RequestHandler) fasthttp.RequestHandler {
+	names := pathParamNames(path)
+	return func(ctx *fasthttp.RequestCtx) {
+		ctx.SetUserValue(keyParamNames, &names)
+		if raw, ok := ctx.UserValue(keyRawPath).([]byte); ok {
+			restorePathParams(ctx, path, raw)
+		}
+		fh(ctx)
+	}
+}
+
+// bareHandler converts a fastglue handler into a fasthttp handler that gets the
+// Request (with the Context) but skips the middleware, for fastglue's own
+// handlers such as the 404 and 405 handlers.
+func (f *Fastglue) bareHandler(h FastRequestHandler) func(*fasthttp.RequestCtx) {
+	return func(ctx *fasthttp.RequestCtx) {
+		req := f.acquireRequest(ctx)
+		defer releaseRequest(req)
+
+		if err := h(req); err != nil && f.errHandler != nil {
+			f.errHandler(req, err)
+		}
+	}
+}
+
+// applyMiddleware applies the given middleware in order and returns false
+// if one of them stops the request. Errors other than ErrAbort are
+// passed to the error handler.
+func (f *Fastglue) applyMiddleware(req *Request, mw []FastMiddlewareFunc) bool {
+	for _, p := range mw {
+		if err := p(req); err != nil {
+			if !errors.Is(err, ErrAbort) && f.errHandler != nil {
+				f.errHandler(req, err)
 			}
+			return false
+		}
+	}
+
+	return true
+}
+
+// AdaptMiddleware converts a FastMiddleware that returns nil to abort
+// into a FastMiddlewareFunc that returns ErrAbort instead.
+func AdaptMiddleware(fm FastMiddleware) FastMiddlewareFunc {
+	return func(r *Request) error {
+		if fm(r) == nil {
+			return ErrAbort
 		}
+		return nil
+	}
+}
 
+func adaptMiddleware(fm []FastMiddleware) []FastMiddlewareFunc {
+	out := make([]FastMiddlewareFunc, 0, len(fm))
+	for _, m := range fm {
+		out = append(out, AdaptMiddleware(m))
 	}
+	return out
+}
+
+// newRequest returns a new Request for the given fasthttp request.
+func (f *Fastglue) newRequest(ctx *fasthttp.RequestCtx) *Request {
+	return &Request{
+		RequestCtx: ctx,
+		Context:    f.context,
+		glue:       f,
+	}
+}
+
+// acquireRequest returns a Request for the given fasthttp request from the pool.
+// It should be returned to the pool with releaseRequest once the request is done.
+func (f *Fastglue) acquireRequest(ctx *fasthttp.RequestCtx) *Request {
+	r := requestPool.Get().(*Request)
+	r.RequestCtx = ctx
+	r.Context = f.context
+	r.glue = f
+	return r
+}
+
+// releaseRequest resets the Request and returns it to the pool.
+func releaseRequest(r *Request) {
+	*r = Request{}
+	requestPool.Put(r)
 }
 
 // Handler returns fastglue's central fasthttp handler that can be registered
 // to a fasthttp server instance.
 func (f *Fastglue) Handler() func(*fasthttp.RequestCtx) {
-	return f.Router.Handler
+	return func(ctx *fasthttp.RequestCtx) {
+		// Set the common response headers (if any) before the request is routed.
+		if len(f.headers) > 0 {
+			req := f.acquireRequest(ctx)
+			for _, h := range f.headers {
+				h(req)
+			}
+			releaseRequest(req)
+		}
+
+		if f.caseInsensitive {
+			if p := ctx.Path(); hasUpper(p) {
+				ctx.SetUserValue(keyRawPath, append([]byte(nil), p...))
+				ctx.URI().SetPathBytes(lowerASCII(p))
+			}
+		}
+
+		if f.methodOverride {
+			overrideMethod(ctx)
+		}
+
+		// The Request is shared with the route's handler so that the transforms and
+		// the response hooks get its handler name and Context (eg: WithContext()).
+		var req *Request
+		if len(f.transforms) > 0 || len(f.onResponse) > 0 {
+			req = f.acquireRequest(ctx)
+			ctx.SetUserValue(keyRequest, req)
+			defer func() {
+				ctx.RemoveUserValue(keyRequest)
+				releaseRequest(req)
+			}()
+		}
+
+		// In maintenance mode, requests to paths that aren't allowed are not routed.
+		if !f.blockMaintenance(ctx) {
+			if len(f.hosts) == 0 || !f.routeHost(ctx) {
+				f.Router.Handler(ctx)
+			}
+		}
+
+		if req == nil {
+			return
+		}
+
+		// Rewrite buffered response bodies with the transforms (if any).
+		if len(f.transforms) > 0 && !ctx.Response.IsBodyStream() {
+			body := ctx.Response.Body()
+			for _, t := range f.transforms {
+				body = t(req, body)
+			}
+			ctx.Response.SetBody(body)
+		}
+
+		// Fire the response hooks (if any) with the final response. Body streams
+		// aren't read as that'd buffer them, so their Content-Length is used.
+		if len(f.onResponse) > 0 {
+			size := ctx.Response.Header.ContentLength()
+			if !ctx.Response.IsBodyStream() {
+				size = len(ctx.Response.Body())
+			}
+			for _, h := range f.onResponse {
+				h(req, ctx.Response.StatusCode(), size)
+			}
+		}
+	}
+}
+
+// maintenanceMode is the maintenance state of a Fastglue instance.
+type maintenanceMode struct {
+	on    bool
+	allow map[string]struct{}
+}
+
+// SetMaintenance toggles maintenance mode in which requests to all paths other
+// than the given allowed paths (eg: /health) are responded to with a 503 error
+// envelope without being routed. It's safe to toggle at runtime, for instance,
+// during deploys.
+func (f *Fastglue) SetMaintenance(on bool, allowPaths []string) {
+	m := &maintenanceMode{on: on, allow: make(map[string]struct{}, len(allowPaths))}
+	for _, p := range allowPaths {
+		m.allow[p] = struct{}{}
+	}
+	f.maintenance.Store(m)
+}
+
+// blockMaintenance sends the maintenance envelope and returns true if the server
+// is in maintenance mode and the request's path isn't allowed.
+func (f *Fastglue) blockMaintenance(ctx *fasthttp.RequestCtx) bool {
+	m, ok := f.maintenance.Load().(*maintenanceMode)
+	if !ok || !m.on {
+		return false
+	}
+	if _, ok := m.allow[string(ctx.Path())]; ok {
+		return false
+	}
+
+	req := f.acquireRequest(ctx)
+	_ = req.SendErrorEnvelope(fasthttp.StatusServiceUnavailable, "Service is under maintenance", nil, excepGeneral)
+	releaseRequest(req)
+	return true
 }
 
 // SetContext sets a "context" which is shared and made available in every HTTP request.
 // This is useful for injecting dependencies such as config structs, DB connections etc.
 // Be very careful to only include immutable variables and thread-safe objects.
+// Middleware can replace it for a request with Request.WithContext().
 func (f *Fastglue) SetContext(c interface{}) {
 	f.context = c
 }
 
+// SetMessageTranslator sets a translator with which the messages in error envelopes
+// are treated as keys and translated to the language in the request's
+// Accept-Language header. If no translator is set, messages are sent as is.
+func (f *Fastglue) SetMessageTranslator(t MessageTranslator) {
+	f.translator = t
+}
+
+// SetErrorHandler sets a central handler that's invoked whenever a registered
+// handler returns a non-nil error. This is useful for mapping domain errors
+// to HTTP responses in one place instead of every handler writing its own.
+// It defaults to DefaultErrorHandler. Setting it to nil discards the errors.
+func (f *Fastglue) SetErrorHandler(h FastErrorHandler) {
+	f.errHandler = h
+}
+
+// SetMaxResponseSize sets the maximum size in bytes of JSON responses sent with
+// SendJSON and SendEnvelope. Responses that exceed it (eg: a handler accidentally
+// returning a massive list) are not written, and instead, a 500 error envelope is
+// sent, the incident is logged, and ErrResponseTooLarge is returned. 0 disables the limit.
+func (f *Fastglue) SetMaxResponseSize(n int) {
+	f.maxResponseSize = n
+}
+
+// SetMaxBatchSize sets the maximum number of sub-requests in a request to a
+// BatchHandler, which defaults to 100. Larger batches are rejected with a 400
+// error envelope. 0 disables the limit.
+func (f *Fastglue) SetMaxBatchSize(n int) {
+	f.maxBatchSize = n
+}
+
+// SetStreamEnvelopes toggles encoding of SendEnvelope's success envelopes directly
+// into the response body instead of marshalling them into an intermediate []byte first,
+// which saves a copy of large payloads. The status code and headers are set before
+// the data is encoded, and on marshal errors, whatever was written is discarded in
+// favour of the error envelope. Streamed envelopes end with a newline.
+func (f *Fastglue) SetStreamEnvelopes(on bool) {
+	f.streamEnvelopes = on
+}
+
 // Before registers a fastglue middleware that's executed before an HTTP request
 // is handed over to the registered handler. This is useful for doing "global"
 // checks, for instance, session and cookies.
 func (f *Fastglue) Before(fm ...FastMiddleware) {
+	f.before = append(f.before, adaptMiddleware(fm)...)
+}
+
+// BeforeFunc is the same as Before() but registers middleware that
+// return an error to abort a request.
+func (f *Fastglue) BeforeFunc(fm ...FastMiddlewareFunc) {
 	f.before = append(f.before, fm...)
 }
 
 // After registers a fastglue middleware that's executed after a registered handler
 // has finished executing. This is useful to do things like central request logging.
 func (f *Fastglue) After(fm ...FastMiddleware) {
+	f.after = append(f.after, adaptMiddleware(fm)...)
+}
+
+// AfterFunc is the same as After() but registers middleware that
+// return an error to abort a request.
+func (f *Fastglue) AfterFunc(fm ...FastMiddlewareFunc) {
 	f.after = append(f.after, fm...)
 }
 
+// Plugin is a middleware with both a "before" and an "after" phase,
+// for instance, metrics, tracing or logging.
+type Plugin interface {
+	Before(*Request) *Request
+	After(*Request) *Request
+}
+
+// Use registers the Before() and After() phases of the given plugins
+// as "before" and "after" middleware respectively.
+func (f *Fastglue) Use(p ...Plugin) {
+	for _, pl := range p {
+		f.Before(pl.Before)
+		f.After(pl.After)
+	}
+}
+
+// Finally registers a fastglue middleware that's always executed at the end of
+// a request, even if a "before" or "after" middleware aborts the request.
+// This is useful for things like access logging of rejected requests.
+func (f *Fastglue) Finally(fm ...FastMiddleware) {
+	f.finally = append(f.finally, adaptMiddleware(fm)...)
+}
+
+// FinallyFunc is the same as Finally() but registers middleware that
+// return an error to abort a request.
+func (f *Fastglue) FinallyFunc(fm ...FastMiddlewareFunc) {
+	f.finally = append(f.finally, fm...)
+}
+
+// OnResponse registers hooks that are executed once for every response
+// written by the server, irrespective of the route that handled it (including
+// 404, 405 and other error responses). Unlike After() middleware, hooks
+// are always run, which makes this a single place for central metrics and logging.
+// Hooks get the same Request as the route's handler, with its HandlerName() and
+// the Context set by middleware (eg: WithContext()).
+func (f *Fastglue) OnResponse(h ...ResponseHook) {
+	f.onResponse = append(f.onResponse, h...)
+}
+
+// Transform registers functions that rewrite the body of every response (including
+// 404, 405 and other error responses) once it's been written, for instance, to inject
+// a nonce into HTML pages. They're run in order before the OnResponse hooks, each
+// receiving the body returned by the previous one. The body passed to a function is
+// only valid until it returns. Streamed response bodies (SetBodyStream) are skipped.
+func (f *Fastglue) Transform(fn ...func(r *Request, body []byte) []byte) {
+	f.transforms = append(f.transforms, fn...)
+}
+
+// ResponseHeaders registers functions that set common headers (eg: app version)
+// on every response, irrespective of the route that handles it (including 404 and
+// 405 responses). They're run before the request is routed, and hence, the headers
+// can be overridden by handlers. The Request they get has the Context.
+func (f *Fastglue) ResponseHeaders(fn ...func(r *Request)) {
+	f.headers = append(f.headers, fn...)
+}
+
 // POST is fastglue's wrapper over fasthttprouter's handler.
-func (f *Fastglue) POST(path string, h FastRequestHandler) {
-	f.Router.POST(path, f.handler(h))
+func (f *Fastglue) POST(path string, h FastRequestHandler, o ...RouteOptions) {
+	f.Router.POST(path, f.withPath(path, f.handler(h, o...)))
+	f.setRouteOptions(fasthttp.MethodPost, path, o)
 }
 
 // GET is fastglue's wrapper over fasthttprouter's handler.
-func (f *Fastglue) GET(path string, h FastRequestHandler) {
-	f.Router.GET(path, f.handler(h))
+func (f *Fastglue) GET(path string, h FastRequestHandler, o ...RouteOptions) {
+	f.Router.GET(path, f.withPath(path, f.handler(h, o...)))
+	f.setRouteOptions(fasthttp.MethodGet, path, o)
 }
 
 // PUT is fastglue's wrapper over fasthttprouter's handler.
-func (f *Fastglue) PUT(path string, h FastRequestHandler) {
-	f.Router.PUT(path, f.handler(h))
+func (f *Fastglue) PUT(path string, h FastRequestHandler, o ...RouteOptions) {
+	f.Router.PUT(path, f.withPath(path, f.handler(h, o...)))
+	f.setRouteOptions(fasthttp.MethodPut, path, o)
 }
 
 // DELETE is fastglue's wrapper over fasthttprouter's handler.
-func (f *Fastglue) DELETE(path string, h FastRequestHandler) {
-	f.Router.DELETE(path, f.handler(h))
+func (f *Fastglue) DELETE(path string, h FastRequestHandler, o ...RouteOptions) {
+	f.Router.DELETE(path, f.withPath(path, f.handler(h, o...)))
+	f.setRouteOptions(fasthttp.MethodDelete, path, o)
 }
 
 // OPTIONS is fastglue's wrapper over fasthttprouter's handler.
-func (f *Fastglue) OPTIONS(path string, h FastRequestHandler) {
-	f.Router.OPTIONS(path, f.handler(h))
+func (f *Fastglue) OPTIONS(path string, h FastRequestHandler, o ...RouteOptions) {
+	f.Router.OPTIONS(path, f.withPath(path, f.handler(h, o...)))
+	f.setRouteOptions(fasthttp.MethodOptions, path, o)
 }
 
 // HEAD is fastglue's wrapper over fasthttprouter's handler.
-func (f *Fastglue) HEAD(path string, h FastRequestHandler) {
-	f.Router.HEAD(path, f.handler(h))
+func (f *Fastglue) HEAD(path string, h FastRequestHandler, o ...RouteOptions) {
+	f.Router.HEAD(path, f.withPath(path, f.handler(h, o...)))
+	f.setRouteOptions(fasthttp.MethodHead, path, o)
 }
 
 // Any is fastglue's wrapper over fasthttprouter's handler
 // that attaches a FastRequestHandler to all
-// GET, POST, PUT, DELETE methods.
-func (f *Fastglue) Any(path string, h FastRequestHandler) {
-	f.Router.GET(path, f.handler(h))
-	f.Router.POST(path, f.handler(h))
-	f.Router.PUT(path, f.handler(h))
-	f.Router.DELETE(path, f.handler(h))
+// GET, POST, PUT, DELETE methods, or to the methods set with SetAnyMethods.
+func (f *Fastglue) Any(path string, h FastRequestHandler, o ...RouteOptions) {
+	methods := f.anyMethods
+	if len(methods) == 0 {
+		methods = defaultAnyMethods
+	}
+
+	fh := f.handler(h, o...)
+	for _, m := range methods {
+		f.Router.Handle(m, path, f.withPath(path, fh))
+		f.setRouteOptions(m, path, o)
+	}
+}
+
+// Handle registers a handler on every combination of the given methods and paths,
+// for instance, for aliased endpoints such as /ping and /v1/ping.
+func (f *Fastglue) Handle(methods []string, paths []string, h FastRequestHandler, o ...RouteOptions) {
+	fh := f.handler(h, o...)
+	for _, p := range paths {
+		ph := f.withPath(p, fh)
+		for _, m := range methods {
+			f.Router.Handle(m, p, ph)
+			f.setRouteOptions(m, p, o)
+		}
+	}
+}
+
+// SetRedirectTrailingSlash toggles the router's automatic redirection of requests
+// to paths with (or without) a trailing slash if only the other variant is registered,
+// eg: /path/ to /path. It's enabled by default. Disabling it makes such requests 404,
+// which is useful for clients that don't follow redirects on POST requests.
+func (f *Fastglue) SetRedirectTrailingSlash(on bool) {
+	f.Router.RedirectTrailingSlash = on
+	f.configureHosts()
+}
+
+// SetRedirectFixedPath toggles the router's automatic redirection of requests to
+// the cleaned up (eg: /../path, //path) and case-insensitively matched version
+// of their paths if they don't match a route as is. It's enabled by default.
+func (f *Fastglue) SetRedirectFixedPath(on bool) {
+	f.Router.RedirectFixedPath = on
+	f.configureHosts()
+}
+
+// SetMethodOverride toggles HTTP method overriding for clients behind proxies that
+// only allow GET and POST. When it's on, POST requests with the X-HTTP-Method-Override
+// header, or the `_method` field in url-encoded form bodies, set to PUT, PATCH or DELETE
+// are routed as requests of that method.
+func (f *Fastglue) SetMethodOverride(on bool) {
+	f.methodOverride = on
+}
+
+// SetUseNumber toggles decoding of JSON numbers in interface{} values (eg: map[string]interface{})
+// by Decode and DecodeStrictJSON as json.Number instead of float64, which loses
+// the precision of integers beyond 2^53 (eg: 64-bit IDs).
+func (f *Fastglue) SetUseNumber(on bool) {
+	f.useNumber = on
+}
+
+// RegisterDecoder registers a decoder that Decode uses for request bodies with the
+// given content type (eg: text/csv), which is matched without its parameters
+// (eg: charset). Registered decoders take precedence over the built-in JSON,
+// XML and form decoding.
+func (f *Fastglue) RegisterDecoder(contentType string, fn func(b []byte, v interface{}) error) {
+	if f.decoders == nil {
+		f.decoders = make(map[string]func([]byte, interface{}) error)
+	}
+	f.decoders[mediaType([]byte(contentType))] = fn
+}
+
+// RegisterEncoder registers an encoder that Request.Send uses for responses with
+// the given content type, which is matched without its parameters (eg: charset).
+// Registered encoders take precedence over the built-in JSON and XML encoders.
+func (f *Fastglue) RegisterEncoder(contentType string, fn func(v interface{}) ([]byte, error)) {
+	if f.encoders == nil {
+		f.encoders = make(map[string]func(interface{}) ([]byte, error))
+	}
+	f.encoders[mediaType([]byte(contentType))] = fn
+}
+
+// SetCaseInsensitivePaths toggles case-insensitive routing, where request paths are
+// lowercased before they're matched, eg: /API/Get matches the route /api/get.
+// Routes should hence be registered in lowercase. Path params retain their values
+// from the original request path, eg: /API/Get/ABC gives the param {id} of the route
+// /api/get/{id} the value ABC, while RequestCtx.Path() in handlers and middleware
+// gets the lowercased path.
+//
+// Security: as the path is normalized before routing, any checks that are done on the
+// raw request URI (eg: by a proxy or WAF in front of the server, or ACLs matching
+// RequestURI()) may see a different path from the one that's routed. Such checks
+// should match paths case-insensitively too.
+func (f *Fastglue) SetCaseInsensitivePaths(on bool) {
+	f.caseInsensitive = on
+}
+
+// SetAnyMethods sets the methods that Any() attaches handlers to, for instance,
+// to additionally include PATCH, OPTIONS and HEAD. It only applies to the
+// handlers registered after it's called.
+func (f *Fastglue) SetAnyMethods(methods ...string) {
+	f.anyMethods = methods
 }
 
 // NotFound is fastglue's wrapper over fasthttprouter's `router.NotFound` handler.
@@ -246,6 +1075,23 @@ func (f *Fastglue) NotFound(h FastRequestHandler) {
 	f.Router.NotFound = f.handler(h)
 }
 
+// SetFallback registers a catch-all handler that's run for requests that don't
+// match any registered route (path or method), for instance, to reverse-proxy
+// them or to serve custom content. Unlike the fixed 404 and 405 handlers, it runs
+// through the same middleware and Request wrapper (with the Context) as the routes.
+// Passing nil restores the enveloped 404 and 405 responses.
+func (f *Fastglue) SetFallback(h FastRequestHandler) {
+	if h == nil {
+		f.Router.NotFound = f.bareHandler(notFound)
+		f.Router.MethodNotAllowed = f.bareHandler(badMethod)
+		return
+	}
+
+	fb := f.handler(h)
+	f.Router.NotFound = fb
+	f.Router.MethodNotAllowed = fb
+}
+
 // ServeStatic serves static files under `rootPath` on `path` urls.
 // The `path` must end with "/{filepath:*}", files are then served from the local
 // path /defined/root/dir/{filepath:*}. For example `path` can be
@@ -273,19 +1119,202 @@ func (f *Fastglue) ServeStatic(path string, rootPath string, listDirectory bool)
 	f.Router.ServeFilesCustom(path, fs)
 }
 
-// Decode unmarshals the Post body of a fasthttp request based on the ContentType header
-// into value pointed to by v, as long as the content is JSON or XML.
-func (r *Request) Decode(v interface{}, tag string) error {
-	var (
-		err error
+// StaticOptions represents the options for serving static files with ServeStaticWithOptions.
+type StaticOptions struct {
+	// CacheControl is the Cache-Control header set on files that are served,
+	// eg: `public, max-age=31536000, immutable` for fingerprinted assets.
+	CacheControl string
+
+	// IndexNames are the index files served for directories. Defaults to index.html.
+	IndexNames []string
+
+	// ListDirectory enables directory listing.
+	ListDirectory bool
+
+	// Compress enables transparent brotli or gzip compression of served files as
+	// per the request's Accept-Encoding header. fasthttp caches the compressed
+	// variants alongside the files (eg: app.js.fasthttp.br) if rootPath is writable,
+	// and otherwise, in memory. Compressed responses are sent with Vary: Accept-Encoding.
+	Compress bool
+
+	// NotFound is an optional file (relative to the root path) that's served with
+	// a 200 for paths that don't exist, for instance, index.html for
+	// single page app deep links. It's served without the CacheControl header.
+	NotFound string
+}
+
+// ServeStaticWithOptions serves static files under `rootPath` on `path` urls like
+// ServeStatic, but with additional options for cache headers, index names,
+// compression, and a fallback file for paths that don't exist.
+func (f *Fastglue) ServeStaticWithOptions(path string, rootPath string, o StaticOptions) {
+	const suffix = "/{filepath:*}"
+	if !strings.HasSuffix(path, suffix) {
+		panic("path must end with " + suffix + " in path '" + path + "'")
+	}
+
+	if len(o.IndexNames) == 0 {
+		o.IndexNames = []string{"index.html"}
+	}
+
+	fs := &fasthttp.FS{
+		Root:               rootPath,
+		IndexNames:         o.IndexNames,
+		GenerateIndexPages: o.ListDirectory,
+		Compress:           o.Compress,
+		CompressBrotli:     o.Compress,
+		AcceptByteRange:    true,
+	}
+
+	// Strip the prefix from the request path to get the file path.
+	if n := strings.Count(path[:len(path)-len(suffix)], "/"); n > 0 {
+		fs.PathRewrite = fasthttp.NewPathSlashesStripper(n)
+	}
+
+	// Serve the fallback file for paths that don't exist.
+	if o.NotFound != "" {
+		notFound := filepath.Join(rootPath, o.NotFound)
+		fs.PathNotFound = func(ctx *fasthttp.RequestCtx) {
+			ctx.SetUserValue(keyStaticFallback, true)
+			ctx.SendFile(notFound)
+		}
+	}
+
+	h := fs.NewRequestHandler()
+	f.Router.GET(path, func(ctx *fasthttp.RequestCtx) {
+		h(ctx)
+
+		// Caches should key compressed files by the Accept-Encoding header.
+		if len(ctx.Response.Header.Peek(fasthttp.HeaderContentEncoding)) > 0 {
+			ctx.Response.Header.Add(fasthttp.HeaderVary, fasthttp.HeaderAcceptEncoding)
+		}
+
+		if o.CacheControl == "" || ctx.UserValue(keyStaticFallback) != nil {
+			return
+		}
+		switch ctx.Response.StatusCode() {
+		case fasthttp.StatusOK, fasthttp.StatusPartialContent, fasthttp.StatusNotModified:
+			ctx.Response.Header.Set("Cache-Control", o.CacheControl)
+		}
+	})
+}
+
+// ServeSPA serves a single page app from `rootDir` on the `prefix` url (eg: /app).
+// Files that exist are served as is and all other paths under the prefix are served
+// the `indexFile` (eg: index.html) so that client-side routing works. Unmatched
+// routes outside of the prefix continue to be handled by the router's NotFound handler.
+func (f *Fastglue) ServeSPA(prefix, indexFile, rootDir string) {
+	prefix = strings.TrimRight(prefix, "/")
+	f.ServeStaticWithOptions(prefix+"/{filepath:*}", rootDir, StaticOptions{
+		IndexNames: []string{indexFile},
+		NotFound:   indexFile,
+	})
+}
+
+// Decode unmarshals the Post body of a fasthttp request based on the ContentType header
+// into value pointed to by v, as long as the content is JSON or XML.
+// Compressed bodies (gzip, deflate, br Content-Encoding) are decompressed first.
+// Decoders for other content types can be registered with Fastglue.RegisterDecoder.
+// JSON bodies with a top-level array can be decoded into a pointer to
+// a slice (eg: *[]Item). Form bodies can only be decoded into structs.
+func (r *Request) Decode(v interface{}, tag string) error {
+	return r.decode(v, tag, false)
+}
+
+// DecodeStrict is the same as Decode but instead of falling back to decoding form
+// values for unrecognized content types, it returns an error. Only JSON, XML and
+// url-encoded form (application/x-www-form-urlencoded) bodies, and those with
+// registered decoders, are accepted.
+// This helps catch clients sending JSON without the right ContentType header.
+func (r *Request) DecodeStrict(v interface{}, tag string) error {
+	return r.decode(v, tag, true)
+}
+
+// DecodeStrictJSON decodes the JSON request body into v, rejecting fields in the
+// body that don't exist in v (eg: typos by clients) with an error naming the field.
+func (r *Request) DecodeStrictJSON(v interface{}) error {
+	body, err := r.Body()
+	if err != nil {
+		return fmt.Errorf("error decoding request: %v", err)
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.DisallowUnknownFields()
+	if r.glue != nil && r.glue.useNumber {
+		dec.UseNumber()
+	}
+
+	if err := dec.Decode(v); err != nil {
+		if f := strings.TrimPrefix(err.Error(), "json: unknown field "); f != err.Error() {
+			return fmt.Errorf("error decoding request: unknown field %s", f)
+		}
+		return fmt.Errorf("error decoding request: %v", err)
+	}
+
+	// There should be nothing other than whitespace after the JSON value.
+	if _, err := dec.Token(); err != io.EOF {
+		return errors.New("error decoding request: unexpected data after JSON body")
+	}
+
+	return nil
+}
+
+// DecodeForm decodes the url-encoded form body of the request into v with
+// UnmarshalArgs, which unlike Decode, supports bracket notation keys
+// (eg: items[0][name]=x) for nested structs and lists.
+func (r *Request) DecodeForm(v interface{}, tag string) error {
+	if _, err := r.Body(); err != nil {
+		return fmt.Errorf("error decoding request: %v", err)
+	}
+	if err := UnmarshalArgs(r.RequestCtx.PostArgs(), v, tag); err != nil {
+		return fmt.Errorf("error decoding request: %v", err)
+	}
+	return nil
+}
+
+// DecodeWithQuery is the same as Decode but additionally applies the query args
+// of the request (eg: POST /items?dry_run=true) onto v using the same field tag after
+// the body is decoded. If a field is set in both the body and the query, the query
+// arg takes precedence.
+func (r *Request) DecodeWithQuery(v interface{}, tag string) error {
+	if err := r.Decode(v, tag); err != nil {
+		return err
+	}
+
+	if _, err := ScanArgs(r.RequestCtx.QueryArgs(), v, tag); err != nil {
+		return fmt.Errorf("error decoding query: %v", err)
+	}
+	return nil
+}
+
+func (r *Request) decode(v interface{}, tag string, strict bool) error {
+	var (
+		err error
 		ct  = r.RequestCtx.Request.Header.ContentType()
 	)
 
+	body, err := r.Body()
+	if err != nil {
+		return fmt.Errorf("error decoding request: %v", err)
+	}
+
+	if r.glue != nil && r.glue.decoders != nil {
+		if fn, ok := r.glue.decoders[mediaType(ct)]; ok {
+			if err := fn(body, v); err != nil {
+				return fmt.Errorf("error decoding request: %v", err)
+			}
+			return nil
+		}
+	}
+
 	// Validate compulsory fields in JSON body. The struct to be unmarshaled into needs a struct tag with required=true for enforcing presence.
 	if bytes.Contains(ct, constJSON) {
-		err = json.Unmarshal(r.RequestCtx.PostBody(), &v)
+		if err = r.unmarshalJSON(body, &v); err != nil {
+			return newDecodeError(err)
+		}
 	} else if bytes.Contains(ct, constXML) {
-		err = xml.Unmarshal(r.RequestCtx.PostBody(), &v)
+		err = xml.Unmarshal(body, &v)
+	} else if strict && !bytes.HasPrefix(ct, constForm) {
+		return fmt.Errorf("error decoding request: unsupported content type `%s`, expected JSON, XML or form", ct)
 	} else {
 		_, err = ScanArgs(r.RequestCtx.PostArgs(), v, tag)
 	}
@@ -295,6 +1324,466 @@ func (r *Request) Decode(v interface{}, tag string) error {
 	return nil
 }
 
+// Body returns the request body, decompressed if the request has a gzip, deflate
+// or br Content-Encoding. The decompressed body replaces the request's body so that
+// it's only decompressed once and PostBody() and PostArgs() read the decompressed body.
+// Like the compressed body, the decompressed body is limited to the server's
+// MaxRequestBodySize (or the route's), beyond which ErrBodyTooLarge is returned.
+func (r *Request) Body() ([]byte, error) {
+	var (
+		zr   io.Reader
+		err  error
+		body = bytes.NewReader(r.RequestCtx.PostBody())
+		enc  = string(bytes.TrimSpace(r.RequestCtx.Request.Header.Peek(fasthttp.HeaderContentEncoding)))
+	)
+
+	switch strings.ToLower(enc) {
+	case "", "identity":
+		return r.RequestCtx.PostBody(), nil
+	case "gzip":
+		zr, err = gzip.NewReader(body)
+	case "deflate":
+		zr, err = zlib.NewReader(body)
+	case "br":
+		zr = brotli.NewReader(body)
+	default:
+		return nil, fmt.Errorf("unsupported content encoding `%s`", enc)
+	}
+
+	var b []byte
+	if err == nil {
+		b, err = readLimited(zr, r.maxBodySize())
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error decompressing %s body: %w", enc, err)
+	}
+
+	r.RequestCtx.Request.SetBody(b)
+	r.RequestCtx.Request.Header.Del(fasthttp.HeaderContentEncoding)
+	return r.RequestCtx.PostBody(), nil
+}
+
+// maxBodySize returns the max size of the request's body.
+func (r *Request) maxBodySize() int {
+	if r.routeOptions != nil && r.routeOptions.MaxBodySize > 0 {
+		return r.routeOptions.MaxBodySize
+	}
+	if r.glue != nil && r.glue.Server != nil && r.glue.Server.MaxRequestBodySize > 0 {
+		return r.glue.Server.MaxRequestBodySize
+	}
+	return fasthttp.DefaultMaxRequestBodySize
+}
+
+// unmarshalJSON is the same as json.Unmarshal but decodes numbers in interface{}
+// values as json.Number if SetUseNumber is on.
+func (r *Request) unmarshalJSON(b []byte, v interface{}) error {
+	if r.glue == nil || !r.glue.useNumber {
+		return json.Unmarshal(b, v)
+	}
+
+	// Get the same errors as json.Unmarshal for invalid JSON (eg: trailing data).
+	if !json.Valid(b) {
+		return json.Unmarshal(b, v)
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	return dec.Decode(v)
+}
+
+// DecodeError is a JSON decoding error that carries the path of the field
+// (eg: items.0.qty) that failed to decode, if it's known, and the byte offset
+// in the body at which decoding failed. DecodeFail sends it as the envelope's data.
+type DecodeError struct {
+	Field   string `json:"field,omitempty"`
+	Offset  int64  `json:"offset"`
+	Message string `json:"message"`
+
+	err error
+}
+
+// newDecodeError returns a DecodeError for an error returned by json.Unmarshal.
+func newDecodeError(err error) *DecodeError {
+	e := &DecodeError{Message: err.Error(), err: err}
+
+	var (
+		te *json.UnmarshalTypeError
+		se *json.SyntaxError
+	)
+	if errors.As(err, &te) {
+		e.Field = te.Field
+		e.Offset = te.Offset
+		e.Message = fmt.Sprintf("expected %s, got %s", te.Type, te.Value)
+	} else if errors.As(err, &se) {
+		e.Offset = se.Offset
+	}
+
+	return e
+}
+
+func (e *DecodeError) Error() string {
+	return "error decoding request: " + e.err.Error()
+}
+
+// Unwrap returns the underlying json error.
+func (e *DecodeError) Unwrap() error {
+	return e.err
+}
+
+// CacheBody is a middleware that caches a copy of the request body so that
+// it can be read with RawBody() by other middleware (eg: signature verification)
+// and handlers. It should be registered with Before().
+func CacheBody(r *Request) *Request {
+	b := r.RequestCtx.PostBody()
+	r.RequestCtx.SetUserValue(keyRawBody, append([]byte(nil), b...))
+	return r
+}
+
+// RawBody returns the raw request body cached by the CacheBody middleware.
+// If the body isn't cached, the request's post body is returned.
+func (r *Request) RawBody() []byte {
+	if b, ok := r.RequestCtx.UserValue(keyRawBody).([]byte); ok {
+		return b
+	}
+	return r.RequestCtx.PostBody()
+}
+
+// stdContext is a request's context.Context that's stored as a user value.
+// fasthttp closes user values implementing io.Closer once the request is done,
+// which cancels the context.
+type stdContext struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func (s *stdContext) Close() error {
+	s.cancel()
+	return nil
+}
+
+// StdContext returns a context.Context that's tied to the lifetime of the request.
+// It's cancelled when the client closes the connection, when the request's
+// Deadline (if any) passes, or when the request is done, whichever is first,
+// and can be passed to outbound calls (DB queries, HTTP requests etc.) made
+// on behalf of the request.
+func (r *Request) StdContext() context.Context {
+	if s, ok := r.RequestCtx.UserValue(keyStdContext).(*stdContext); ok {
+		return s.ctx
+	}
+
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+	)
+	if d, ok := r.Deadline(); ok {
+		ctx, cancel = context.WithDeadline(context.Background(), d)
+	} else {
+		ctx, cancel = context.WithCancel(context.Background())
+	}
+	s := &stdContext{ctx: ctx, cancel: cancel}
+	r.RequestCtx.SetUserValue(keyStdContext, s)
+
+	// Requests that aren't bound to a connection (eg: mock requests) can't be watched.
+	conn := r.RequestCtx.Conn()
+	if conn == nil {
+		return ctx
+	}
+
+	go func() {
+		t := time.NewTicker(connCheckInterval)
+		defer t.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+				if closed, ok := isConnClosed(conn); !ok {
+					return
+				} else if closed {
+					cancel()
+					return
+				}
+			}
+		}
+	}()
+
+	return ctx
+}
+
+// Detach returns a copy of the request that's safe to use in goroutines that outlive
+// the handler. fasthttp recycles the RequestCtx once the handler returns, after
+// which reading from it is a use-after-free bug. The method, URI, headers, body,
+// remote address and user values (eg: path params) of the request are copied into
+// a standalone RequestCtx. The Context is shared with the original request.
+//
+// The detached request isn't bound to a connection, so anything written to its
+// response is discarded and its StdContext() isn't cancelled when the original
+// request is done.
+func (r *Request) Detach() *Request {
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Init(&r.RequestCtx.Request, r.RequestCtx.RemoteAddr(), nil)
+
+	r.RequestCtx.VisitUserValues(func(k []byte, v interface{}) {
+		// Values that are closed when the request is done belong to the original request,
+		// as does the pooled Request that's shared with the response hooks.
+		if _, ok := v.(io.Closer); ok {
+			return
+		}
+		if _, ok := v.(*Request); ok {
+			return
+		}
+
+		switch o := v.(type) {
+		case string:
+			v = string(append([]byte(nil), o...))
+		case []byte:
+			v = append([]byte(nil), o...)
+		}
+		ctx.SetUserValue(string(k), v)
+	})
+
+	return &Request{
+		RequestCtx:      ctx,
+		Context:         r.Context,
+		glue:            r.glue,
+		handlerName:     r.handlerName,
+		routeOptions:    r.routeOptions,
+		jsonContentType: r.jsonContentType,
+	}
+}
+
+// Elapsed returns the time elapsed since the request started, as recorded by fasthttp
+// when it began handling the request. It returns 0 if the request start time is unknown.
+func (r *Request) Elapsed() time.Duration {
+	start := r.RequestCtx.Time()
+	if start.IsZero() {
+		return 0
+	}
+	return time.Since(start)
+}
+
+// multipartForm is a parsed multipart form that's stored as a user value.
+// fasthttp closes it once the request is done, which removes its temporary files.
+type multipartForm struct {
+	*multipart.Form
+}
+
+func (m multipartForm) Close() error {
+	return m.RemoveAll()
+}
+
+// MultipartForm returns the request's parsed multipart form. If MultipartMaxMemory
+// is set on the Fastglue instance, the form is parsed with files beyond that many
+// bytes spilled to disk. Otherwise, it's the same as fasthttp's RequestCtx.MultipartForm.
+func (r *Request) MultipartForm() (*multipart.Form, error) {
+	if r.glue == nil || r.glue.MultipartMaxMemory <= 0 {
+		return r.RequestCtx.MultipartForm()
+	}
+
+	if m, ok := r.RequestCtx.UserValue(keyMultipartForm).(multipartForm); ok {
+		return m.Form, nil
+	}
+
+	boundary := r.RequestCtx.Request.Header.MultipartFormBoundary()
+	if len(boundary) == 0 {
+		return nil, fasthttp.ErrNoMultipartForm
+	}
+
+	mr := multipart.NewReader(bytes.NewReader(r.RequestCtx.PostBody()), string(boundary))
+	form, err := mr.ReadForm(r.glue.MultipartMaxMemory)
+	if err != nil {
+		if errors.Is(err, multipart.ErrMessageTooLarge) {
+			return nil, fmt.Errorf("multipart form exceeds the memory limit of %d bytes: %w", r.glue.MultipartMaxMemory, err)
+		}
+		return nil, fmt.Errorf("error parsing multipart form: %w", err)
+	}
+	r.RequestCtx.SetUserValue(keyMultipartForm, multipartForm{form})
+
+	return form, nil
+}
+
+// Deadline returns the time by which the response to the request has to be
+// written, derived from the WriteTimeout of the server the request is being
+// served by (the ReadTimeout has already been spent reading the request by the
+// time the handler is invoked), or the route's RouteOptions.Timeout.
+// ok is false if there's no timeout.
+// Handlers can use this to bail out of long work they can't finish in time.
+func (r *Request) Deadline() (deadline time.Time, ok bool) {
+	if r.glue == nil {
+		return time.Time{}, false
+	}
+
+	var timeout time.Duration
+	if r.glue.Server != nil {
+		timeout = r.glue.Server.WriteTimeout
+	}
+	if r.routeOptions != nil && r.routeOptions.Timeout > 0 {
+		timeout = r.routeOptions.Timeout
+	}
+
+	start := r.RequestCtx.Time()
+	if timeout <= 0 || start.IsZero() {
+		return time.Time{}, false
+	}
+	return start.Add(timeout), true
+}
+
+// HandlerName returns the function name of the handler of the matched route
+// (eg: main.handleGetUser, or main.main.func1 for anonymous functions), which is
+// useful for correlating errors and slow requests to code. It's empty for
+// requests that don't match a route, such as 404s.
+func (r *Request) HandlerName() string {
+	return r.handlerName
+}
+
+// WithContext replaces the request's Context with the given value and returns
+// the request. It's meant for Before() middleware that derive a per-request
+// context from the global one (eg: a tenant scoped DB handle), which is then
+// what the handler and the subsequent middleware get, eg:
+//
+//	g.Before(func(r *fastglue.Request) *fastglue.Request {
+//		return r.WithContext(r.Context.(*App).ForTenant(r.Param("tenant")))
+//	})
+//
+// The global context set with SetContext() is left untouched.
+func (r *Request) WithContext(v interface{}) *Request {
+	r.Context = v
+	return r
+}
+
+// Param returns the value of the given route (path) param, for instance, `id`
+// in the route /users/{id}. An empty string is returned if the param doesn't exist.
+func (r *Request) Param(name string) string {
+	v, _ := r.RequestCtx.UserValue(name).(string)
+	return v
+}
+
+// QueryString returns the value of the given query arg or def if it doesn't exist.
+func (r *Request) QueryString(key string, def string) string {
+	return argString(r.RequestCtx.QueryArgs(), key, def)
+}
+
+// QueryInt returns the value of the given query arg as an int or def
+// if it doesn't exist or isn't a valid int.
+func (r *Request) QueryInt(key string, def int) int {
+	return argInt(r.RequestCtx.QueryArgs(), key, def)
+}
+
+// QueryFloat returns the value of the given query arg as a float64 or def
+// if it doesn't exist or isn't a valid decimal.
+func (r *Request) QueryFloat(key string, def float64) float64 {
+	return argFloat(r.RequestCtx.QueryArgs(), key, def)
+}
+
+// QueryBool returns the value of the given query arg as a bool or def
+// if it doesn't exist or isn't a valid boolean (as per strconv.ParseBool).
+func (r *Request) QueryBool(key string, def bool) bool {
+	return argBool(r.RequestCtx.QueryArgs(), key, def)
+}
+
+// FormString returns the value of the given POST form value or def if it doesn't exist.
+func (r *Request) FormString(key string, def string) string {
+	return argString(r.RequestCtx.PostArgs(), key, def)
+}
+
+// FormInt returns the value of the given POST form value as an int or def
+// if it doesn't exist or isn't a valid int.
+func (r *Request) FormInt(key string, def int) int {
+	return argInt(r.RequestCtx.PostArgs(), key, def)
+}
+
+// FormFloat returns the value of the given POST form value as a float64 or def
+// if it doesn't exist or isn't a valid decimal.
+func (r *Request) FormFloat(key string, def float64) float64 {
+	return argFloat(r.RequestCtx.PostArgs(), key, def)
+}
+
+// FormBool returns the value of the given POST form value as a bool or def
+// if it doesn't exist or isn't a valid boolean (as per strconv.ParseBool).
+func (r *Request) FormBool(key string, def bool) bool {
+	return argBool(r.RequestCtx.PostArgs(), key, def)
+}
+
+// QueryMap returns a snapshot of all the query args of the request as a map
+// of keys to their values in the order in which they appear.
+func (r *Request) QueryMap() map[string][]string {
+	return argsMap(r.RequestCtx.QueryArgs())
+}
+
+// FormMap returns a snapshot of all the POST form values of the request as a map
+// of keys to their values in the order in which they appear. Like the other Form*
+// getters, it doesn't include the values of multipart forms.
+func (r *Request) FormMap() map[string][]string {
+	return argsMap(r.RequestCtx.PostArgs())
+}
+
+// Params returns the route (path) params of the matched route, including the
+// wildcard labels of its HostRouter (if any), as a map. Other user values set on
+// the request (eg: by middleware) aren't included.
+func (r *Request) Params() map[string]string {
+	out := make(map[string]string)
+	for _, k := range []string{keyHostParamNames, keyParamNames} {
+		names, _ := r.RequestCtx.UserValue(k).(*[]string)
+		if names == nil {
+			continue
+		}
+		for _, n := range *names {
+			if v, ok := r.RequestCtx.UserValue(n).(string); ok {
+				out[n] = v
+			}
+		}
+	}
+
+	return out
+}
+
+// RoutePattern returns the route template that matched the request, for instance,
+// /users/{id}. This requires Router.SaveMatchedRoutePath to be enabled, which
+// NewGlue() does. An empty string is returned otherwise.
+func (r *Request) RoutePattern() string {
+	v, _ := r.RequestCtx.UserValue(fasthttprouter.MatchedRoutePathParam).(string)
+	return v
+}
+
+// DecodePath unmarshals the route (path) params of the request into the struct
+// pointed to by v using the given field tag. For instance, the route
+// /users/{id} can be decoded into struct{ ID int `path:"id"` }.
+func (r *Request) DecodePath(v interface{}, tag string) error {
+	args := fasthttp.AcquireArgs()
+	defer fasthttp.ReleaseArgs(args)
+
+	for k, val := range r.Params() {
+		args.Add(k, val)
+	}
+
+	if _, err := ScanArgs(args, v, tag); err != nil {
+		return fmt.Errorf("error decoding path params: %v", err)
+	}
+	return nil
+}
+
+// DecodeAll unmarshals the route (path) params, the query args and the body of the
+// request into the struct pointed to by v. Path params are mapped with the `path` tag,
+// query args with the `url` tag, and the body is decoded with Decode() based on the
+// ContentType header (`json` and `xml` tags, or the `url` tag for form bodies).
+// The sources are applied in the order path, query, body, so a value in a later
+// source overwrites the same field set by an earlier one.
+func (r *Request) DecodeAll(v interface{}) error {
+	if err := r.DecodePath(v, "path"); err != nil {
+		return err
+	}
+
+	if _, err := ScanArgs(r.RequestCtx.QueryArgs(), v, "url"); err != nil {
+		return fmt.Errorf("error decoding query: %v", err)
+	}
+
+	if len(r.RequestCtx.PostBody()) == 0 {
+		return nil
+	}
+	return r.Decode(v, "url")
+}
+
 // SendBytes writes a []byte payload to the HTTP response and also
 // sets a given ContentType header.
 func (r *Request) SendBytes(code int, ctype string, v []byte) error {
@@ -307,6 +1796,66 @@ func (r *Request) SendBytes(code int, ctype string, v []byte) error {
 	return nil
 }
 
+// SendStream streams the content (eg: a generated video or export) to the HTTP response
+// with the given ContentType, supporting range requests (eg: for media playback).
+// Requests with a single `Range: bytes=...` header are sent the requested bytes with a 206
+// and the Content-Range header, and unsatisfiable ranges get a 416. Requests for multiple
+// ranges are sent the whole content. If content implements io.Closer, it's closed
+// once the response is written, or right away if it isn't streamed (eg: on a 416).
+func (r *Request) SendStream(ctype string, content io.ReadSeeker) error {
+	size, err := content.Seek(0, io.SeekEnd)
+	if err != nil {
+		closeStream(content)
+		return err
+	}
+
+	var (
+		code  = fasthttp.StatusOK
+		start int64
+		n     = size
+	)
+	r.RequestCtx.Response.Header.Set(fasthttp.HeaderAcceptRanges, "bytes")
+
+	if rng := r.RequestCtx.Request.Header.Peek(fasthttp.HeaderRange); len(rng) > 0 && bytes.IndexByte(rng, ',') < 0 {
+		s, e, err := fasthttp.ParseByteRange(rng, int(size))
+		if err != nil {
+			r.RequestCtx.Response.Header.Set(fasthttp.HeaderContentRange, "bytes */"+strconv.FormatInt(size, 10))
+			r.RequestCtx.SetStatusCode(fasthttp.StatusRequestedRangeNotSatisfiable)
+			closeStream(content)
+			return nil
+		}
+
+		code, start, n = fasthttp.StatusPartialContent, int64(s), int64(e-s+1)
+		r.RequestCtx.Response.Header.SetContentRange(s, e, int(size))
+	}
+
+	if _, err := content.Seek(start, io.SeekStart); err != nil {
+		closeStream(content)
+		return err
+	}
+
+	// fasthttp closes body streams that implement io.Closer.
+	var body io.Reader = io.LimitReader(content, n)
+	if c, ok := content.(io.Closer); ok {
+		body = struct {
+			io.Reader
+			io.Closer
+		}{body, c}
+	}
+
+	r.RequestCtx.SetStatusCode(code)
+	r.RequestCtx.SetContentType(ctype)
+	r.RequestCtx.SetBodyStream(body, int(n))
+	return nil
+}
+
+// closeStream closes the content of a stream if it implements io.Closer.
+func closeStream(content io.Reader) {
+	if c, ok := content.(io.Closer); ok {
+		_ = c.Close()
+	}
+}
+
 // SendString writes a string payload to the HTTP response.
 // It implicitly sets ContentType to plain/text.
 func (r *Request) SendString(code int, v string) error {
@@ -319,11 +1868,73 @@ func (r *Request) SendString(code int, v string) error {
 	return nil
 }
 
+// SetCacheControl sets the Cache-Control header on the response allowing the
+// response to be cached for maxAge, either by shared caches (public) or only
+// by the client (private).
+func (r *Request) SetCacheControl(maxAge time.Duration, public bool) {
+	scope := "private"
+	if public {
+		scope = "public"
+	}
+	r.RequestCtx.Response.Header.Set(fasthttp.HeaderCacheControl,
+		scope+", max-age="+strconv.FormatInt(int64(maxAge/time.Second), 10))
+}
+
+// NoStore sets the Cache-Control header on the response to prevent
+// it from being stored by any cache.
+func (r *Request) NoStore() {
+	r.RequestCtx.Response.Header.Set(fasthttp.HeaderCacheControl, "no-store")
+}
+
+// SendEarlyHints writes an interim 103 Early Hints response with the given Link header
+// values (eg: `</style.css>; rel=preload; as=style`) so that clients can start preloading
+// resources while the handler produces the final response. The links should
+// also be set on the final response for clients that ignore early hints.
+//
+// fasthttp doesn't support interim responses, so the 103 is written directly to
+// the connection as the final response is only written after the handler returns.
+// ErrEarlyHintsUnsupported is returned for HTTP/1.0 requests and requests that
+// aren't bound to a connection (eg: mock or detached requests). Clients that
+// pipeline requests aren't supported as their earlier responses may not be written yet.
+func (r *Request) SendEarlyHints(links []string) error {
+	conn := r.RequestCtx.Conn()
+	if conn == nil || !r.RequestCtx.Request.Header.IsHTTP11() {
+		return ErrEarlyHintsUnsupported
+	}
+
+	// Requests initialized with RequestCtx.Init (eg: detached requests)
+	// have a fake connection with a zero local address.
+	if a, ok := conn.LocalAddr().(*net.TCPAddr); ok && a.Port == 0 {
+		return ErrEarlyHintsUnsupported
+	}
+
+	b := []byte("HTTP/1.1 103 Early Hints\r\n")
+	for _, l := range links {
+		if strings.ContainsAny(l, "\r\n") {
+			return fmt.Errorf("invalid link `%s`", l)
+		}
+		b = append(b, "Link: "+l+"\r\n"...)
+	}
+	b = append(b, "\r\n"...)
+
+	_, err := conn.Write(b)
+	return err
+}
+
+// SendNoContent sends a bare 204 No Content response without a body,
+// for instance, for successful DELETE requests.
+func (r *Request) SendNoContent() error {
+	r.RequestCtx.Response.ResetBody()
+	r.RequestCtx.SetStatusCode(fasthttp.StatusNoContent)
+	return nil
+}
+
 // SendJSON takes an interface, marshals it to JSON, and writes the
-// result to the HTTP response. It implicitly sets ContentType to application/json.
+// result to the HTTP response. It implicitly sets ContentType to application/json,
+// or to the one set with SetJSONContentType.
 func (r *Request) SendJSON(code int, v interface{}) error {
 	r.RequestCtx.SetStatusCode(code)
-	r.RequestCtx.SetContentType(JSON)
+	r.RequestCtx.SetContentType(r.jsonType())
 
 	var (
 		b   []byte
@@ -334,6 +1945,10 @@ func (r *Request) SendJSON(code int, v interface{}) error {
 		return err
 	}
 
+	if r.isTooLarge(len(b)) {
+		return r.sendTooLarge(len(b))
+	}
+
 	if _, err := r.RequestCtx.Write(b); err != nil {
 		return err
 	}
@@ -341,10 +1956,153 @@ func (r *Request) SendJSON(code int, v interface{}) error {
 	return nil
 }
 
+// SetJSONContentType sets the content type of the JSON responses sent for the
+// request with SendJSON and the envelope methods, in place of application/json,
+// for instance, application/vnd.api+json. It's typically set by middleware.
+func (r *Request) SetJSONContentType(ct string) {
+	r.jsonContentType = ct
+}
+
+// jsonType returns the content type of the request's JSON responses.
+func (r *Request) jsonType() string {
+	if r.jsonContentType != "" {
+		return r.jsonContentType
+	}
+	return JSON
+}
+
+// Send encodes v with the encoder registered for the content type with
+// Fastglue.RegisterEncoder, or with the built-in JSON and XML encoders,
+// and writes it to the HTTP response with the given content type.
+func (r *Request) Send(code int, contentType string, v interface{}) error {
+	var (
+		mt = mediaType([]byte(contentType))
+		fn func(interface{}) ([]byte, error)
+	)
+	if r.glue != nil {
+		fn = r.glue.encoders[mt]
+	}
+	if fn == nil {
+		switch mt {
+		case JSON:
+			fn = json.Marshal
+		case XML:
+			fn = xml.Marshal
+		default:
+			return fmt.Errorf("no encoder for content type `%s`", contentType)
+		}
+	}
+
+	b, err := fn(v)
+	if err != nil {
+		return err
+	}
+
+	if r.isTooLarge(len(b)) {
+		return r.sendTooLarge(len(b))
+	}
+
+	return r.SendBytes(code, contentType, b)
+}
+
+// SendNDJSON streams the items received on the channel to the HTTP response as
+// newline delimited JSON (NDJSON), one JSON encoded item per line, so that clients
+// can process them as they arrive. Items are streamed after the handler returns
+// until the channel is closed, the client goes away, or an item can't be encoded.
+// Producers should stop on the request's StdContext() being done, which happens
+// once the stream stops, as nothing reads from the channel after that.
+func (r *Request) SendNDJSON(code int, ch <-chan interface{}) error {
+	r.RequestCtx.SetStatusCode(code)
+	r.RequestCtx.SetContentType(NDJSON)
+	r.RequestCtx.SetBodyStreamWriter(func(w *bufio.Writer) {
+		for v := range ch {
+			b, err := json.Marshal(v)
+			if err != nil {
+				log.Printf("error marshalling NDJSON item: %v", err)
+				return
+			}
+
+			if _, err := w.Write(append(b, '\n')); err != nil {
+				return
+			}
+
+			// Flush every item and stop if the client is gone.
+			if err := w.Flush(); err != nil {
+				return
+			}
+		}
+	})
+
+	return nil
+}
+
+// SendJSONModified is the same as SendJSON but sets the Last-Modified header to
+// modTime and responds with a bare 304 Not Modified if the request's If-Modified-Since
+// header is not older than modTime, for responses generated from content with a
+// known modification time. Non-2xx responses (eg: errors) are sent as is.
+func (r *Request) SendJSONModified(code int, v interface{}, modTime time.Time) error {
+	if code < fasthttp.StatusOK || code >= fasthttp.StatusMultipleChoices {
+		return r.SendJSON(code, v)
+	}
+
+	modTime = modTime.UTC().Truncate(time.Second)
+	r.RequestCtx.Response.Header.Set(fasthttp.HeaderLastModified, string(fasthttp.AppendHTTPDate(nil, modTime)))
+
+	if ims := r.RequestCtx.Request.Header.Peek(fasthttp.HeaderIfModifiedSince); len(ims) > 0 {
+		if t, err := fasthttp.ParseHTTPDate(ims); err == nil && !t.Before(modTime) {
+			r.RequestCtx.Response.ResetBody()
+			r.RequestCtx.SetStatusCode(fasthttp.StatusNotModified)
+			return nil
+		}
+	}
+
+	return r.SendJSON(code, v)
+}
+
 // Redirect redirects to the given URL.
 // Accepts optional query args and anchor tags.
 // Test : curl -I -L -X GET "localhost:8000/redirect"
 func (r *Request) Redirect(url string, code int, args map[string]interface{}, anchor string) error {
+	return r.redirect(url, code, args, anchor, false)
+}
+
+// RedirectWithQuery is the same as Redirect but also carries over the current request's
+// query args to the redirect URL. Args that are set on the given URL or in `args`
+// take precedence over the current request's args.
+func (r *Request) RedirectWithQuery(url string, code int, args map[string]interface{}, anchor string) error {
+	return r.redirect(url, code, args, anchor, true)
+}
+
+// RedirectPermanent redirects to the given URL with a 301 status.
+// Accepts optional query args.
+func (r *Request) RedirectPermanent(url string, args map[string]interface{}) error {
+	return r.Redirect(url, fasthttp.StatusMovedPermanently, args, "")
+}
+
+// RedirectTemporary redirects to the given URL with a 302 status.
+// Accepts optional query args.
+func (r *Request) RedirectTemporary(url string, args map[string]interface{}) error {
+	return r.Redirect(url, fasthttp.StatusFound, args, "")
+}
+
+// RedirectWithCookies sets the given cookies on the response and redirects to the
+// given URL, for instance, to set a session cookie after a login. Cookies that don't
+// have a SameSite mode are set with SameSite=Lax so that they're sent on the
+// navigation that follows the redirect without being sent on cross-site subrequests.
+func (r *Request) RedirectWithCookies(url string, code int, cookies []*fasthttp.Cookie) error {
+	for _, c := range cookies {
+		var ck fasthttp.Cookie
+		ck.CopyTo(c)
+		if ck.SameSite() == fasthttp.CookieSameSiteDisabled {
+			ck.SetSameSite(fasthttp.CookieSameSiteLaxMode)
+		}
+		r.RequestCtx.Response.Header.SetCookie(&ck)
+	}
+
+	return r.Redirect(url, code, nil, "")
+}
+
+func (r *Request) redirect(url string, code int, args map[string]interface{}, anchor string, preserveQuery bool) error {
 	var redirectURI string
 
 	// Copy current url before mutating.
@@ -352,6 +2110,16 @@ func (r *Request) Redirect(url string, code int, args map[string]interface{}, an
 	r.RequestCtx.URI().CopyTo(rURI)
 	rURI.Update(url)
 
+	// Carry over the current query args that aren't overridden.
+	if preserveQuery {
+		r.RequestCtx.QueryArgs().VisitAll(func(k, v []byte) {
+			if _, ok := args[string(k)]; ok || rURI.QueryArgs().Has(string(k)) {
+				return
+			}
+			rURI.QueryArgs().AddBytesKV(k, v)
+		})
+	}
+
 	// This avoids a redirect vulnerability when `uri` is relative and contains double slash.
 	// For example: if the `uri` is `/bing.com//` which is a relative path passed from client side,
 	// `rURI.Update(uri)` doesn't set the hostname hence the updated uri becomes `http:///bing.com/`.
@@ -421,6 +2189,58 @@ func (r *Request) RedirectURI(uri string, code int, args map[string]interface{},
 	return r.Redirect(fURI, code, args, anchor)
 }
 
+// Language returns the preferred language in the request's Accept-Language header,
+// for instance, en-US for `Accept-Language: en-US,en;q=0.9`. An empty string is
+// returned if the header is absent.
+func (r *Request) Language() string {
+	var (
+		h    = r.RequestCtx.Request.Header.Peek("Accept-Language")
+		lang string
+		maxQ = -1.0
+	)
+
+	for _, part := range strings.Split(string(h), ",") {
+		var (
+			p = strings.Split(part, ";")
+			l = strings.TrimSpace(p[0])
+			q = 1.0
+		)
+		if l == "" || l == "*" {
+			continue
+		}
+
+		for _, attr := range p[1:] {
+			attr = strings.TrimSpace(attr)
+			if strings.HasPrefix(attr, "q=") {
+				v, err := strconv.ParseFloat(attr[2:], 64)
+				if err != nil {
+					v = 0
+				}
+				q = v
+			}
+		}
+
+		if q > maxQ {
+			lang = l
+			maxQ = q
+		}
+	}
+
+	return lang
+}
+
+// translate translates a message key with the registered translator, if any.
+func (r *Request) translate(key string) string {
+	if r.glue == nil || r.glue.translator == nil {
+		return key
+	}
+
+	if msg := r.glue.translator(r.Language(), key); msg != "" {
+		return msg
+	}
+	return key
+}
+
 // ParseAuthHeader parses the Authorization header and returns an api_key and access_token
 // based on the auth schemes passed as bit flags (eg: AuthBasic, AuthBasic | AuthToken etc.).
 func (r *Request) ParseAuthHeader(schemes uint8) ([]byte, []byte, error) {
@@ -450,3 +2270,50 @@ func (r *Request) ParseAuthHeader(schemes uint8) ([]byte, []byte, error) {
 
 	return pair[0], pair[1], nil
 }
+
+// ClientIP returns the IP address of the client that originated the request.
+// If the immediate peer (RemoteIP) is one of the trusted proxies, the X-Forwarded-For
+// header is walked from right to left (the order in which proxies append to it) and
+// the first address that isn't a trusted proxy is returned. Addresses to the left of it
+// are ignored as they can be forged by the client. If the walk hits an invalid address
+// or every address is a trusted proxy, the peer's address is returned. X-Real-IP is only
+// used if there's no X-Forwarded-For header. If the peer isn't a trusted proxy, its
+// address is returned as is.
+func (r *Request) ClientIP(trustedProxies []net.IPNet) string {
+	remote := r.RequestCtx.RemoteIP()
+	if !isTrustedIP(remote, trustedProxies) {
+		return remote.String()
+	}
+
+	if xff := r.RequestCtx.Request.Header.Peek("X-Forwarded-For"); len(xff) > 0 {
+		ips := strings.Split(string(xff), ",")
+		for i := len(ips) - 1; i >= 0; i-- {
+			ip := net.ParseIP(strings.TrimSpace(ips[i]))
+			if ip == nil {
+				// An invalid address in the chain can't be trusted.
+				break
+			}
+			if !isTrustedIP(ip, trustedProxies) {
+				return ip.String()
+			}
+		}
+
+		return remote.String()
+	}
+
+	if ip := net.ParseIP(strings.TrimSpace(string(r.RequestCtx.Request.Header.Peek("X-Real-IP")))); ip != nil {
+		return ip.String()
+	}
+
+	return remote.String()
+}
+
+// isTrustedIP checks whether ip belongs to one of the given networks.
+func isTrustedIP(ip net.IP, nets []net.IPNet) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -1,19 +1,32 @@
 package fastglue
 
 import (
+	"bufio"
 	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/csv"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
+	"mime/multipart"
 	"net"
 	"net/http"
 	"net/url"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"reflect"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"testing"
 	"time"
 
@@ -57,6 +70,8 @@ func init() {
 	srv.GET("/next", myNextRedirectHandler)
 	srv.GET("/next-uri", myNextRedirectURIHandler)
 	srv.GET("/redirect", myRedirectHandler)
+	srv.GET("/redirect-query", myRedirectQueryHandler)
+	srv.GET("/redirect-permanent", myRedirectPermanentHandler)
 	srv.DELETE("/delete", myGEThandler)
 	srv.POST("/post", myPOSThandler)
 	srv.PUT("/put", myPOSThandler)
@@ -68,9 +83,14 @@ func init() {
 	srv.POST("/required_length", ReqLenParams(myGEThandler, map[string]int{"name": 5}))
 	srv.GET("/required_length_range", ReqLenRangeParams(myGEThandler, map[string][2]int{"name": {5, 10}}))
 	srv.POST("/required_length_range", ReqLenRangeParams(myGEThandler, map[string][2]int{"name": {5, 10}}))
+	srv.POST("/max_body_size", ReqMaxBodySize(myPOSTJsonhandler, 64))
 	srv.Any("/any", myAnyHandler)
 	srv.ServeStatic("/dir-examples/{filepath:*}", "./examples", true)
 	srv.ServeStatic("/no-dir-examples/{filepath:*}", "./examples", false)
+	srv.ServeStaticWithOptions("/opt-examples/{filepath:*}", "./examples", StaticOptions{
+		CacheControl: "public, max-age=31536000, immutable",
+		NotFound:     "index.html",
+	})
 
 	log.Println("Listening on Test Server", srvAddress)
 	go (func() {
@@ -172,6 +192,19 @@ func myRedirectHandler(r *Request) error {
 	}, "")
 }
 
+func myRedirectQueryHandler(r *Request) error {
+	return r.RedirectWithQuery("/get", fasthttp.StatusFound, map[string]interface{}{
+		"name": "Redirected" + string(r.RequestCtx.FormValue("name")),
+	}, "")
+}
+
+func myRedirectPermanentHandler(r *Request) error {
+	return r.RedirectPermanent("/get", map[string]interface{}{
+		"param": "123",
+		"name":  "permanent",
+	})
+}
+
 func myNextRedirectHandler(r *Request) error {
 	next := r.RequestCtx.QueryArgs().Peek("next")
 	if len(next) > 0 {
@@ -253,6 +286,52 @@ func TestSocketConnection(t *testing.T) {
 	defer c.Close()
 }
 
+func TestListenAndServeTLS(t *testing.T) {
+	cert, key, err := fasthttp.GenerateTestCertificate("127.0.0.1")
+	require.NoError(t, err)
+	pair, err := tls.X509KeyPair(cert, key)
+	require.NoError(t, err)
+
+	g := NewGlue()
+	g.GET("/", func(r *Request) error {
+		return r.SendEnvelope("secure")
+	})
+
+	// Serve TLS on a random port.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	var (
+		ch   = make(chan struct{})
+		done = make(chan error, 1)
+	)
+	go func() {
+		done <- g.ServeGracefully(tls.NewListener(ln, &tls.Config{Certificates: []tls.Certificate{pair}}), nil, ch)
+	}()
+
+	c := http.Client{
+		Timeout: time.Second * 3,
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		},
+	}
+	resp, err := c.Get("https://" + ln.Addr().String() + "/")
+	require.NoError(t, err)
+	require.Equal(t, fasthttp.StatusOK, resp.StatusCode)
+
+	e, _ := decodeEnvelope(resp, t)
+	require.Equal(t, "secure", e.Data)
+
+	ch <- struct{}{}
+	require.NoError(t, <-done)
+
+	// Missing addresses, certificates and certificate files.
+	require.Error(t, g.ListenAndServeTLS("127.0.0.1:0", "", "", nil))
+	require.Error(t, g.ListenAndServeTLS("", "cert.pem", "key.pem", nil))
+	require.Error(t, g.ListenAndServeTLSEmbed("127.0.0.1:0", nil, nil, nil))
+	require.Error(t, g.ListenAndServeTLSEmbed("", cert, key, nil))
+}
+
 func Test404Response(t *testing.T) {
 	resp := GETrequest(srvRoot+"/404", t)
 
@@ -488,6 +567,24 @@ func TestRequiredParamsLenRange(t *testing.T) {
 	}
 }
 
+func TestMaxBodySize(t *testing.T) {
+	resp := POSTJsonRequest(srvRoot+"/max_body_size?param=123", []byte(`{"name": "tester", "age": 30}`), t)
+	if resp.StatusCode != fasthttp.StatusOK {
+		t.Fatalf("Expected status %d != %d", fasthttp.StatusOK, resp.StatusCode)
+	}
+
+	resp = POSTJsonRequest(srvRoot+"/max_body_size?param=123",
+		[]byte(`{"name": "`+strings.Repeat("x", 100)+`", "age": 30}`), t)
+	if resp.StatusCode != fasthttp.StatusRequestEntityTooLarge {
+		t.Fatalf("Expected status %d != %d", fasthttp.StatusRequestEntityTooLarge, resp.StatusCode)
+	}
+
+	e, b := decodeEnvelope(resp, t)
+	if e.Status != "error" || e.ErrorType == nil || *e.ErrorType != "InputException" {
+		t.Fatalf("Incorrect status or error_type fields: %s", b)
+	}
+}
+
 func TestBadPOSTJsonRequest(t *testing.T) {
 	// Struct that we'll marshal to JSON and post.
 	resp := POSTJsonRequest(srvRoot+"/post_json?param=123&name=test", []byte{0}, t)
@@ -589,6 +686,39 @@ func TestRedirectRequest(t *testing.T) {
 	}
 }
 
+func TestRedirectWithQuery(t *testing.T) {
+	// `param` is carried over from the current request and `name` is overridden.
+	resp := GETrequest(srvRoot+"/redirect-query?param=123&name=test", t)
+	if resp.StatusCode != fasthttp.StatusOK {
+		t.Fatalf("Expected status %d != %d", fasthttp.StatusOK, resp.StatusCode)
+	}
+
+	if resp.Request.URL.Query().Get("param") != "123" || len(resp.Request.URL.Query()["name"]) != 1 {
+		t.Fatalf("Query args not carried over in redirect: %s", resp.Request.URL.String())
+	}
+
+	e, _ := decodeEnvelope(resp, t)
+	out := "map[out:name=Redirectedtest]"
+	if fmt.Sprintf("%v", e.Data) != out {
+		t.Fatalf("Expected `data` field %s != %v", out, e.Data)
+	}
+}
+
+func TestRedirectPermanent(t *testing.T) {
+	c := http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	resp, err := c.Get(srvRoot + "/redirect-permanent?param=123")
+	if err != nil {
+		t.Fatalf("Failed GET request: %v", err)
+	}
+	if resp.StatusCode != fasthttp.StatusMovedPermanently {
+		t.Fatalf("Expected status %d != %d", fasthttp.StatusMovedPermanently, resp.StatusCode)
+	}
+}
+
 func TestRedirectScheme(t *testing.T) {
 	req, _ := http.NewRequest("GET", srvRoot+"/redirect?param=123&name=test", nil)
 
@@ -814,6 +944,243 @@ func TestScanArgs(t *testing.T) {
 		}
 
 	}
+
+	// Fields with omitempty are excluded from the list of scanned fields if they're empty.
+	args = fasthttp.AcquireArgs()
+	args.Set("otherval", "")
+	fields, err := ScanArgs(args, &o, "form")
+	require.NoError(t, err)
+	require.Equal(t, []string{"otherval"}, fields)
+
+	args.Set("otherval", "val")
+	fields, err = ScanArgs(args, &o, "form")
+	require.NoError(t, err)
+	require.Equal(t, []string{"otherval", "otherval"}, fields)
+	require.Equal(t, "val", o.OmitEmpty)
+}
+
+type argType struct {
+	Vals []string
+}
+
+func (a *argType) UnmarshalArg(s string) error {
+	if s == "" {
+		return errors.New("empty value")
+	}
+	a.Vals = strings.Split(s, "|")
+	return nil
+}
+
+var _ ArgUnmarshaler = (*argType)(nil)
+
+func TestScanArgsUnmarshaler(t *testing.T) {
+	type test struct {
+		Custom    argType   `url:"custom"`
+		CustomPtr *argType  `url:"custom_ptr"`
+		Customs   []argType `url:"customs"`
+		NotSet    *argType  `url:"not_set"`
+	}
+	var o test
+
+	args := fasthttp.AcquireArgs()
+	args.Add("custom", "a|b")
+	args.Add("custom_ptr", "c")
+	args.Add("customs", "d|e")
+	args.Add("customs", "f")
+
+	fields, err := ScanArgs(args, &o, "url")
+	require.NoError(t, err)
+	require.Equal(t, []string{"custom", "custom_ptr", "customs"}, fields)
+	require.Equal(t, test{
+		Custom:    argType{Vals: []string{"a", "b"}},
+		CustomPtr: &argType{Vals: []string{"c"}},
+		Customs:   []argType{{Vals: []string{"d", "e"}}, {Vals: []string{"f"}}},
+	}, o)
+
+	args.Set("custom", "")
+	_, err = ScanArgs(args, &o, "url")
+	require.EqualError(t, err, "failed to decode `custom`, got: `` (empty value)")
+}
+
+func TestScanArgsOneOf(t *testing.T) {
+	type test struct {
+		Sort   string   `url:"sort,oneof=asc desc"`
+		Fields []string `url:"field,oneof=name age"`
+	}
+
+	args := fasthttp.AcquireArgs()
+	args.Add("sort", "desc")
+	args.Add("field", "name")
+	args.Add("field", "age")
+
+	var o test
+	_, err := ScanArgs(args, &o, "url")
+	require.NoError(t, err)
+	require.Equal(t, test{Sort: "desc", Fields: []string{"name", "age"}}, o)
+
+	args.Set("sort", "random")
+	_, err = ScanArgs(args, &o, "url")
+	require.EqualError(t, err, "failed to decode `sort`, got: `random` (expected one of: asc, desc)")
+
+	args.Set("sort", "asc")
+	args.Add("field", "height")
+	_, err = ScanArgs(args, &o, "url")
+	require.EqualError(t, err, "failed to decode `field`, got: `height` (expected one of: name, age)")
+}
+
+func TestScanArgsNumericSlices(t *testing.T) {
+	type test struct {
+		Uints  []uint    `url:"uint"`
+		Floats []float64 `url:"float"`
+		Bytes  []uint8   `url:"bytes"`
+	}
+
+	scan := func(q string) (test, error) {
+		var (
+			o    test
+			args fasthttp.Args
+		)
+		args.Parse(q)
+		_, err := ScanArgs(&args, &o, "url")
+		return o, err
+	}
+
+	o, err := scan("uint=1&uint=2&float=1.5&float=-2&bytes=abc")
+	require.NoError(t, err)
+	require.Equal(t, test{Uints: []uint{1, 2}, Floats: []float64{1.5, -2}, Bytes: []byte("abc")}, o)
+
+	// Slice elements are validated the same as scalars.
+	for _, c := range []struct {
+		q, err string
+	}{
+		{"uint=1&uint=-2", "failed to decode `uint`, got: `-2` (expected unsigned int)"},
+		{"float=1&float=NaN", "failed to decode `float`, got: `NaN` (expected decimal)"},
+		{"float=Inf", "failed to decode `float`, got: `Inf` (expected decimal)"},
+		{"float=-inf", "failed to decode `float`, got: `-inf` (expected decimal)"},
+	} {
+		_, err := scan(c.q)
+		require.EqualError(t, err, c.err, c.q)
+	}
+}
+
+func TestScanArgsCSV(t *testing.T) {
+	type test struct {
+		IDs    []int    `url:"ids,csv"`
+		Fields []string `url:"field,csv,oneof=name age"`
+	}
+
+	scan := func(q string) (test, error) {
+		var (
+			o    test
+			args fasthttp.Args
+		)
+		args.Parse(q)
+		_, err := ScanArgs(&args, &o, "url")
+		return o, err
+	}
+
+	// Repeated and comma separated values land in the same field.
+	for _, q := range []string{"ids=1&ids=2&ids=3", "ids=1,2,3", "ids=1,2&ids=3", "ids=1, 2,,3,"} {
+		o, err := scan(q)
+		require.NoError(t, err, q)
+		require.Equal(t, []int{1, 2, 3}, o.IDs, q)
+	}
+
+	o, err := scan("field=name,age")
+	require.NoError(t, err)
+	require.Equal(t, []string{"name", "age"}, o.Fields)
+
+	_, err = scan("field=name,height")
+	require.EqualError(t, err, "failed to decode `field`, got: `height` (expected one of: name, age)")
+
+	_, err = scan("ids=1,x")
+	require.Error(t, err)
+}
+
+func TestScanArgsAll(t *testing.T) {
+	type test struct {
+		Name string `url:"name"`
+		Age  int    `url:"age"`
+		Qty  []int  `url:"qty"`
+		Sort string `url:"sort,oneof=asc desc"`
+	}
+
+	args := fasthttp.AcquireArgs()
+	args.Add("name", "tester")
+	args.Add("age", "abc")
+	args.Add("qty", "1")
+	args.Add("qty", "x")
+	args.Add("sort", "asc")
+
+	var o test
+	fields, err := ScanArgsAll(args, &o, "url")
+	require.Error(t, err)
+	require.Equal(t, []string{"name", "sort"}, fields)
+
+	fe, ok := err.(FieldErrors)
+	require.True(t, ok)
+	require.Len(t, fe, 2)
+	require.EqualError(t, fe["age"], "failed to decode `age`, got: `abc` (expected int)")
+	require.EqualError(t, fe["qty"], "failed to decode `qty`, got: `x` (expected int)")
+	require.Equal(t, "failed to decode `age`, got: `abc` (expected int); failed to decode `qty`, got: `x` (expected int)", err.Error())
+
+	// Both errors in the envelope.
+	req := &Request{RequestCtx: &fasthttp.RequestCtx{}}
+	require.NoError(t, req.SendFieldErrorsEnvelope("Invalid fields", fe))
+	require.Equal(t, fasthttp.StatusBadRequest, req.RequestCtx.Response.StatusCode())
+
+	var e Envelope
+	require.NoError(t, json.Unmarshal(req.RequestCtx.Response.Body(), &e))
+	require.Equal(t, map[string]interface{}{
+		"age": "failed to decode `age`, got: `abc` (expected int)",
+		"qty": "failed to decode `qty`, got: `x` (expected int)",
+	}, e.Data)
+}
+
+type Pagination struct {
+	Page    int `url:"page"`
+	PerPage int `url:"per_page"`
+}
+
+type Sorting struct {
+	Sort string `url:"sort"`
+}
+
+func TestScanArgsEmbedded(t *testing.T) {
+	type test struct {
+		Pagination
+		*Sorting
+		Query string `url:"q"`
+	}
+
+	args := fasthttp.AcquireArgs()
+	args.Add("q", "search")
+	args.Add("page", "2")
+	args.Add("per_page", "50")
+	args.Add("sort", "asc")
+
+	var o test
+	fields, err := ScanArgs(args, &o, "url")
+	require.NoError(t, err)
+	require.Equal(t, []string{"page", "per_page", "sort", "q"}, fields)
+	require.Equal(t, test{
+		Pagination: Pagination{Page: 2, PerPage: 50},
+		Sorting:    &Sorting{Sort: "asc"},
+		Query:      "search",
+	}, o)
+
+	// Errors in embedded fields.
+	args.Set("page", "abc")
+	_, err = ScanArgs(args, &o, "url")
+	require.EqualError(t, err, "failed to decode `page`, got: `abc` (expected int)")
+
+	// Nil embedded pointers stay nil if none of their fields are set.
+	args = fasthttp.AcquireArgs()
+	args.Add("q", "search")
+	o = test{}
+	_, err = ScanArgs(args, &o, "url")
+	require.NoError(t, err)
+	require.Nil(t, o.Sorting)
 }
 
 func TestServeStatic(t *testing.T) {
@@ -854,12 +1221,120 @@ func TestServeStatic(t *testing.T) {
 	}
 }
 
+func TestServeStaticWithOptions(t *testing.T) {
+	index, err := ioutil.ReadFile("./examples/index.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Existing file with cache headers.
+	resp := GETrequest(srvRoot+"/opt-examples/example.go", t)
+	if resp.StatusCode != fasthttp.StatusOK {
+		t.Fatalf("Expected status %d != %d", fasthttp.StatusOK, resp.StatusCode)
+	}
+	if cc := resp.Header.Get("Cache-Control"); cc != "public, max-age=31536000, immutable" {
+		t.Fatalf("Unexpected Cache-Control header: %s", cc)
+	}
+
+	// Unknown paths fall back to the index file without cache headers.
+	resp = GETrequest(srvRoot+"/opt-examples/deep/link", t)
+	if resp.StatusCode != fasthttp.StatusOK {
+		t.Fatalf("Expected status %d != %d", fasthttp.StatusOK, resp.StatusCode)
+	}
+	if cc := resp.Header.Get("Cache-Control"); cc != "" {
+		t.Fatalf("Unexpected Cache-Control header on fallback: %s", cc)
+	}
+	b, _ := ioutil.ReadAll(resp.Body)
+	if !bytes.Equal(b, index) {
+		t.Fatalf("Expected the index file as the fallback, got: %s", b)
+	}
+}
+
+func TestServeStaticCompress(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fastglue")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	js := bytes.Repeat([]byte("console.log('fastglue');\n"), 100)
+	require.NoError(t, ioutil.WriteFile(dir+"/app.js", js, 0644))
+
+	g := NewGlue()
+	g.ServeStaticWithOptions("/assets/{filepath:*}", dir, StaticOptions{Compress: true})
+
+	get := func(enc string) *fasthttp.RequestCtx {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Init(&fasthttp.Request{}, nil, nil)
+		ctx.Request.SetRequestURI("/assets/app.js")
+		if enc != "" {
+			ctx.Request.Header.Set(fasthttp.HeaderAcceptEncoding, enc)
+		}
+		g.Handler()(ctx)
+		require.Equal(t, fasthttp.StatusOK, ctx.Response.StatusCode(), enc)
+		return ctx
+	}
+
+	ctx := get("gzip")
+	require.Equal(t, "gzip", string(ctx.Response.Header.Peek(fasthttp.HeaderContentEncoding)))
+	require.Equal(t, fasthttp.HeaderAcceptEncoding, string(ctx.Response.Header.Peek(fasthttp.HeaderVary)))
+	b, err := ctx.Response.BodyGunzip()
+	require.NoError(t, err)
+	require.Equal(t, js, b)
+
+	ctx = get("gzip, br")
+	require.Equal(t, "br", string(ctx.Response.Header.Peek(fasthttp.HeaderContentEncoding)))
+	b, err = ctx.Response.BodyUnbrotli()
+	require.NoError(t, err)
+	require.Equal(t, js, b)
+
+	// Clients that don't accept compression get the file as is.
+	ctx = get("")
+	require.Empty(t, ctx.Response.Header.Peek(fasthttp.HeaderContentEncoding))
+	require.Empty(t, ctx.Response.Header.Peek(fasthttp.HeaderVary))
+	require.Equal(t, js, ctx.Response.Body())
+}
+
+func TestServeSPA(t *testing.T) {
+	index, err := ioutil.ReadFile("./examples/index.html")
+	require.NoError(t, err)
+
+	g := NewGlue()
+	g.ServeSPA("/app", "index.html", "./examples")
+	g.GET("/api/get", myGEThandler)
+
+	for _, uri := range []string{"/app/", "/app/deep/link"} {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Init(&fasthttp.Request{}, nil, nil)
+		ctx.Request.SetRequestURI(uri)
+		g.Handler()(ctx)
+		require.Equal(t, fasthttp.StatusOK, ctx.Response.StatusCode(), uri)
+		require.Equal(t, index, ctx.Response.Body(), uri)
+	}
+
+	// Real files are served as is.
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Init(&fasthttp.Request{}, nil, nil)
+	ctx.Request.SetRequestURI("/app/example.go")
+	g.Handler()(ctx)
+	require.Equal(t, fasthttp.StatusOK, ctx.Response.StatusCode())
+	require.NotEqual(t, index, ctx.Response.Body())
+
+	// API routes still 404 with the envelope.
+	ctx = &fasthttp.RequestCtx{}
+	ctx.Request.SetRequestURI("/api/x")
+	g.Handler()(ctx)
+	require.Equal(t, fasthttp.StatusNotFound, ctx.Response.StatusCode())
+
+	var e Envelope
+	require.NoError(t, json.Unmarshal(ctx.Response.Body(), &e))
+	require.Equal(t, "error", e.Status)
+}
+
 func TestGrace(t *testing.T) {
 	s := fasthttp.Server{}
 
 	ch := make(chan struct{})
 
-	sig := make(chan os.Signal)
+	sig := make(chan os.Signal, 1)
 	signal.Notify(sig, os.Interrupt)
 	g := New()
 	g.GET("/", func(r *Request) error {
@@ -888,3 +1363,2572 @@ func TestGrace(t *testing.T) {
 	ch <- struct{}{}
 	wg.Wait()
 }
+
+func TestOnResponse(t *testing.T) {
+	var (
+		status int
+		size   int
+	)
+
+	g := NewGlue()
+	g.GET("/", func(r *Request) error {
+		return r.SendString(fasthttp.StatusOK, "hello")
+	})
+	g.OnResponse(func(r *Request, s int, b int) {
+		status = s
+		size = b
+	})
+
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Request.SetRequestURI("/")
+	g.Handler()(ctx)
+	require.Equal(t, fasthttp.StatusOK, status)
+	require.Equal(t, len("hello"), size)
+
+	// 404s served by the router should also fire the hook.
+	ctx = &fasthttp.RequestCtx{}
+	ctx.Request.SetRequestURI("/404")
+	g.Handler()(ctx)
+	require.Equal(t, fasthttp.StatusNotFound, status)
+	require.Equal(t, len(ctx.Response.Body()), size)
+
+	// Body streams aren't buffered to get their size.
+	g.GET("/stream", func(r *Request) error {
+		ch := make(chan interface{})
+		close(ch)
+		return r.SendNDJSON(fasthttp.StatusOK, ch)
+	})
+	g.GET("/file", func(r *Request) error {
+		return r.SendStream("text/plain", strings.NewReader("hello"))
+	})
+
+	ctx = &fasthttp.RequestCtx{}
+	ctx.Request.SetRequestURI("/stream")
+	g.Handler()(ctx)
+	require.True(t, ctx.Response.IsBodyStream())
+	require.Equal(t, -1, size)
+
+	ctx = &fasthttp.RequestCtx{}
+	ctx.Request.SetRequestURI("/file")
+	g.Handler()(ctx)
+	require.True(t, ctx.Response.IsBodyStream())
+	require.Equal(t, len("hello"), size)
+
+	// Hooks and transforms get the handler's Request with its name and Context.
+	var hookName, hookCtx, transformCtx interface{}
+	g = NewGlue()
+	g.SetContext("global")
+	g.BeforeFunc(func(r *Request) error {
+		r.WithContext("request")
+		return nil
+	})
+	g.GET("/", myGEThandler)
+	g.Transform(func(r *Request, body []byte) []byte {
+		transformCtx = r.Context
+		return body
+	})
+	g.OnResponse(func(r *Request, s int, b int) {
+		hookName = r.HandlerName()
+		hookCtx = r.Context
+	})
+
+	ctx = &fasthttp.RequestCtx{}
+	ctx.Request.SetRequestURI("/")
+	g.Handler()(ctx)
+	require.Equal(t, "github.com/zerodha/fastglue.myGEThandler", hookName)
+	require.Equal(t, "request", hookCtx)
+	require.Equal(t, "request", transformCtx)
+}
+
+var errNotFound = errors.New("not found")
+
+func TestErrorHandler(t *testing.T) {
+	g := NewGlue()
+	g.GET("/", func(r *Request) error {
+		return errNotFound
+	})
+	g.GET("/fail", func(r *Request) error {
+		return errors.New("unknown")
+	})
+
+	// Default error handler.
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Request.SetRequestURI("/fail")
+	g.Handler()(ctx)
+	require.Equal(t, fasthttp.StatusInternalServerError, ctx.Response.StatusCode())
+
+	// New() has the default error handler too.
+	g2 := New()
+	g2.GET("/fail", func(r *Request) error {
+		return errors.New("unknown")
+	})
+	ctx = &fasthttp.RequestCtx{}
+	ctx.Request.SetRequestURI("/fail")
+	g2.Handler()(ctx)
+	require.Equal(t, fasthttp.StatusInternalServerError, ctx.Response.StatusCode())
+
+	// Custom error handler that maps the sentinel error to a 404.
+	g.SetErrorHandler(func(r *Request, err error) {
+		if errors.Is(err, errNotFound) {
+			_ = r.SendErrorEnvelope(fasthttp.StatusNotFound, err.Error(), nil, "NotFoundException")
+			return
+		}
+		DefaultErrorHandler(r, err)
+	})
+
+	ctx = &fasthttp.RequestCtx{}
+	ctx.Request.SetRequestURI("/")
+	g.Handler()(ctx)
+	require.Equal(t, fasthttp.StatusNotFound, ctx.Response.StatusCode())
+
+	var e Envelope
+	require.NoError(t, json.Unmarshal(ctx.Response.Body(), &e))
+	require.Equal(t, "not found", *e.Message)
+	require.Equal(t, ErrorType("NotFoundException"), *e.ErrorType)
+
+	// Errors after a body stream has been set leave the response untouched.
+	g.GET("/stream", func(r *Request) error {
+		_ = r.SendStream("text/plain", strings.NewReader("hello"))
+		return errors.New("unknown")
+	})
+	ctx = &fasthttp.RequestCtx{}
+	ctx.Request.SetRequestURI("/stream")
+	g.Handler()(ctx)
+	require.True(t, ctx.Response.IsBodyStream())
+	require.Equal(t, "hello", string(ctx.Response.Body()))
+}
+
+func TestHTTPError(t *testing.T) {
+	g := NewGlue()
+	g.GET("/", func(r *Request) error {
+		return &HTTPError{
+			Code:      fasthttp.StatusNotFound,
+			Message:   "user not found",
+			ErrorType: "UserException",
+		}
+	})
+	g.GET("/wrapped", func(r *Request) error {
+		return fmt.Errorf("wrapped: %w", &HTTPError{Code: fasthttp.StatusForbidden, Message: "forbidden"})
+	})
+	g.GET("/unknown", func(r *Request) error {
+		return errors.New("unknown")
+	})
+	g.GET("/written", func(r *Request) error {
+		_ = r.SendEnvelope("partial")
+		return &HTTPError{Code: fasthttp.StatusConflict, Message: "conflict"}
+	})
+
+	for _, c := range []struct {
+		uri   string
+		code  int
+		msg   string
+		excep string
+	}{
+		{"/", fasthttp.StatusNotFound, "user not found", "UserException"},
+		{"/wrapped", fasthttp.StatusForbidden, "forbidden", ""},
+		{"/unknown", fasthttp.StatusInternalServerError, "Internal server error", excepGeneral},
+		{"/written", fasthttp.StatusConflict, "conflict", ""},
+	} {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.SetRequestURI(c.uri)
+		g.Handler()(ctx)
+		require.Equal(t, c.code, ctx.Response.StatusCode(), c.uri)
+
+		var e Envelope
+		require.NoError(t, json.Unmarshal(ctx.Response.Body(), &e))
+		require.Equal(t, c.msg, *e.Message)
+		if c.excep == "" {
+			require.Nil(t, e.ErrorType)
+		} else {
+			require.Equal(t, ErrorType(c.excep), *e.ErrorType)
+		}
+	}
+}
+
+func TestDecodeSlice(t *testing.T) {
+	req := &Request{RequestCtx: &fasthttp.RequestCtx{}}
+	req.RequestCtx.Request.Header.SetContentType(JSON)
+	req.RequestCtx.Request.SetBody([]byte(`[{"name": "a", "age": 20}, {"name": "b", "age": 30}]`))
+
+	var p []Person
+	require.NoError(t, req.Decode(&p, "json"))
+	require.Equal(t, []Person{{Name: "a", Age: 20}, {Name: "b", Age: 30}}, p)
+
+	// Form values can't be decoded into a slice.
+	req = &Request{RequestCtx: &fasthttp.RequestCtx{}}
+	req.RequestCtx.Request.Header.SetContentType("application/x-www-form-urlencoded")
+	req.RequestCtx.Request.SetBody([]byte(`name=a&age=20`))
+
+	p = nil
+	err := req.Decode(&p, "json")
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "can't decode into slice or array type")
+}
+
+func TestServeGracefully(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	g := NewGlue()
+	g.GET("/", func(r *Request) error {
+		return r.SendEnvelope(true)
+	})
+
+	var (
+		ch   = make(chan struct{})
+		done = make(chan error, 1)
+	)
+	go func() {
+		done <- g.ServeGracefully(ln, nil, ch)
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	resp := GETrequest("http://"+ln.Addr().String()+"/", t)
+	require.Equal(t, fasthttp.StatusOK, resp.StatusCode)
+	resp.Body.Close()
+
+	ch <- struct{}{}
+	select {
+	case err := <-done:
+		require.NoError(t, err)
+	case <-time.After(3 * time.Second):
+		t.Fatal("server didn't shutdown")
+	}
+}
+
+func TestParams(t *testing.T) {
+	g := NewGlue()
+	g.BeforeFunc(func(r *Request) error {
+		r.RequestCtx.SetUserValue("user", "admin")
+		return nil
+	})
+	g.GET("/users/{id}/{action?}", func(r *Request) error {
+		return r.SendJSON(fasthttp.StatusOK, map[string]interface{}{
+			"id":      r.Param("id"),
+			"missing": r.Param("missing"),
+			"params":  r.Params(),
+		})
+	})
+
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Request.SetRequestURI("/users/123/edit")
+	g.Handler()(ctx)
+	require.Equal(t, fasthttp.StatusOK, ctx.Response.StatusCode())
+	require.JSONEq(t, `{"id": "123", "missing": "", "params": {"id": "123", "action": "edit"}}`,
+		string(ctx.Response.Body()))
+
+	// Host params are included.
+	g.Host("{tenant}.example.com").GET("/items/{id}", func(r *Request) error {
+		return r.SendJSON(fasthttp.StatusOK, r.Params())
+	})
+	ctx = &fasthttp.RequestCtx{}
+	ctx.Request.SetRequestURI("http://acme.example.com/items/1")
+	g.Handler()(ctx)
+	require.Equal(t, fasthttp.StatusOK, ctx.Response.StatusCode())
+	require.JSONEq(t, `{"tenant": "acme", "id": "1"}`, string(ctx.Response.Body()))
+}
+
+func TestDecodePath(t *testing.T) {
+	type post struct {
+		UserID int    `path:"id"`
+		Slug   string `path:"slug"`
+	}
+
+	g := NewGlue()
+	g.GET("/users/{id}/posts/{slug}", func(r *Request) error {
+		var p post
+		if err := r.DecodePath(&p, "path"); err != nil {
+			return r.SendErrorEnvelope(fasthttp.StatusBadRequest, err.Error(), nil, "InputException")
+		}
+		return r.SendEnvelope(p)
+	})
+
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Request.SetRequestURI("/users/123/posts/hello-world")
+	g.Handler()(ctx)
+	require.Equal(t, fasthttp.StatusOK, ctx.Response.StatusCode())
+	require.JSONEq(t, `{"status": "success", "data": {"UserID": 123, "Slug": "hello-world"}}`,
+		string(ctx.Response.Body()))
+
+	ctx = &fasthttp.RequestCtx{}
+	ctx.Request.SetRequestURI("/users/abc/posts/hello-world")
+	g.Handler()(ctx)
+	require.Equal(t, fasthttp.StatusBadRequest, ctx.Response.StatusCode())
+
+	var e Envelope
+	require.NoError(t, json.Unmarshal(ctx.Response.Body(), &e))
+	require.Equal(t, "error decoding path params: failed to decode `id`, got: `abc` (expected int)", *e.Message)
+}
+
+func TestDecodeAll(t *testing.T) {
+	type order struct {
+		UserID   int    `path:"id"`
+		Page     int    `url:"page"`
+		Symbol   string `json:"symbol"`
+		Quantity int    `json:"quantity"`
+	}
+
+	g := NewGlue()
+	g.POST("/users/{id}/orders", func(r *Request) error {
+		var o order
+		if err := r.DecodeAll(&o); err != nil {
+			return r.SendErrorEnvelope(fasthttp.StatusBadRequest, err.Error(), nil, "InputException")
+		}
+		return r.SendEnvelope(o)
+	})
+
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Request.Header.SetMethod(fasthttp.MethodPost)
+	ctx.Request.SetRequestURI("/users/123/orders?page=2")
+	ctx.Request.Header.SetContentType(JSON)
+	ctx.Request.SetBody([]byte(`{"symbol": "INFY", "quantity": 10}`))
+	g.Handler()(ctx)
+	require.Equal(t, fasthttp.StatusOK, ctx.Response.StatusCode())
+	require.JSONEq(t, `{"status": "success", "data": {"UserID": 123, "Page": 2, "symbol": "INFY", "quantity": 10}}`,
+		string(ctx.Response.Body()))
+
+	ctx = &fasthttp.RequestCtx{}
+	ctx.Request.Header.SetMethod(fasthttp.MethodPost)
+	ctx.Request.SetRequestURI("/users/123/orders?page=abc")
+	g.Handler()(ctx)
+	require.Equal(t, fasthttp.StatusBadRequest, ctx.Response.StatusCode())
+}
+
+func TestShutdownTimeout(t *testing.T) {
+	g := New()
+	g.SetShutdownTimeout(200 * time.Millisecond)
+	g.GET("/", func(r *Request) error {
+		time.Sleep(5 * time.Second)
+		return r.SendEnvelope(true)
+	})
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	var (
+		ch   = make(chan struct{})
+		done = make(chan error, 1)
+	)
+	go func() {
+		done <- g.ServeGracefully(ln, nil, ch)
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	// Fire a slow request that would otherwise block shutdown.
+	go func() {
+		c := http.Client{Timeout: 6 * time.Second}
+		if resp, err := c.Get("http://" + ln.Addr().String() + "/"); err == nil {
+			resp.Body.Close()
+		}
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	start := time.Now()
+	ch <- struct{}{}
+	select {
+	case err := <-done:
+		require.Equal(t, ErrShutdownTimeout, err)
+		require.Less(t, int64(time.Since(start)), int64(time.Second))
+	case <-time.After(3 * time.Second):
+		t.Fatal("shutdown blocked past the timeout")
+	}
+}
+
+func TestListenAndServeGracefulSignals(t *testing.T) {
+	var (
+		sigs = make(chan chan<- os.Signal, 1)
+		sub  []os.Signal
+	)
+	signalNotify = func(c chan<- os.Signal, s ...os.Signal) {
+		sub = s
+		sigs <- c
+	}
+	defer func() { signalNotify = signal.Notify }()
+
+	// Serve on a UNIX socket instead of a fixed TCP port.
+	var (
+		g    = New()
+		sock = filepath.Join(t.TempDir(), "fastglue.sock")
+		c    = http.Client{Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, "unix", sock)
+			},
+			DisableKeepAlives: true,
+		}}
+		done = make(chan error, 1)
+	)
+	g.GET("/", func(r *Request) error {
+		return r.SendEnvelope(true)
+	})
+	go func() {
+		done <- g.ListenAndServeGracefulSignals("", sock, nil)
+	}()
+
+	sig := <-sigs
+	require.Equal(t, []os.Signal{os.Interrupt, syscall.SIGTERM}, sub)
+
+	time.Sleep(100 * time.Millisecond)
+	resp, err := c.Get("http://fastglue/")
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, fasthttp.StatusOK, resp.StatusCode)
+
+	sig <- syscall.SIGTERM
+	select {
+	case err := <-done:
+		require.NoError(t, err)
+	case <-time.After(3 * time.Second):
+		t.Fatal("server didn't shut down on the signal")
+	}
+
+	_, err = c.Get("http://fastglue/")
+	require.Error(t, err)
+}
+
+func TestFinally(t *testing.T) {
+	var statuses []int
+
+	g := NewGlue()
+	g.Before(getParamMiddleware)
+	g.Finally(func(r *Request) *Request {
+		statuses = append(statuses, r.RequestCtx.Response.StatusCode())
+		return r
+	})
+	g.GET("/", myGEThandler)
+
+	// Aborted by the before middleware.
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Request.SetRequestURI("/")
+	g.Handler()(ctx)
+
+	ctx = &fasthttp.RequestCtx{}
+	ctx.Request.SetRequestURI("/?param=123")
+	g.Handler()(ctx)
+
+	require.Equal(t, []int{fasthttp.StatusBadRequest, fasthttp.StatusOK}, statuses)
+}
+
+func TestMiddlewareFunc(t *testing.T) {
+	g := NewGlue()
+	g.BeforeFunc(func(r *Request) error {
+		switch string(r.RequestCtx.QueryArgs().Peek("mode")) {
+		case "abort":
+			// Explicit abort after writing a response.
+			_ = r.SendErrorEnvelope(fasthttp.StatusUnauthorized, "unauthorized", nil, "AuthException")
+			return ErrAbort
+		case "fail":
+			// Failure that's passed to the error handler.
+			return errors.New("session store down")
+		}
+		return nil
+	})
+	g.Before(getParamMiddleware)
+	g.GET("/", myGEThandler)
+
+	for _, c := range []struct {
+		uri  string
+		code int
+	}{
+		{"/?mode=abort", fasthttp.StatusUnauthorized},
+		{"/?mode=fail", fasthttp.StatusInternalServerError},
+		{"/?mode=ok", fasthttp.StatusBadRequest},
+		{"/?mode=ok&param=123", fasthttp.StatusOK},
+	} {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.SetRequestURI(c.uri)
+		g.Handler()(ctx)
+		require.Equal(t, c.code, ctx.Response.StatusCode(), c.uri)
+	}
+}
+
+func TestRoutePattern(t *testing.T) {
+	g := NewGlue()
+	g.GET("/users/{id}/posts/{slug}", func(r *Request) error {
+		return r.SendString(fasthttp.StatusOK, r.RoutePattern())
+	})
+
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Request.SetRequestURI("/users/123/posts/hello")
+	g.Handler()(ctx)
+	require.Equal(t, "/users/{id}/posts/{slug}", string(ctx.Response.Body()))
+}
+
+func TestClientIP(t *testing.T) {
+	_, lb, _ := net.ParseCIDR("10.0.0.0/8")
+	trusted := []net.IPNet{*lb}
+
+	newReq := func(remote string, headers map[string]string) *Request {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Init(&fasthttp.Request{}, &net.TCPAddr{IP: net.ParseIP(remote)}, nil)
+		for k, v := range headers {
+			ctx.Request.Header.Set(k, v)
+		}
+		return &Request{RequestCtx: ctx}
+	}
+
+	for _, c := range []struct {
+		remote  string
+		headers map[string]string
+		exp     string
+	}{
+		// Direct connection from an untrusted client with a forged header.
+		{"1.1.1.1", map[string]string{"X-Forwarded-For": "2.2.2.2"}, "1.1.1.1"},
+		// Via the trusted LB.
+		{"10.0.0.1", map[string]string{"X-Forwarded-For": "2.2.2.2"}, "2.2.2.2"},
+		// Via the trusted LB with the client forging an address to the left.
+		{"10.0.0.1", map[string]string{"X-Forwarded-For": "9.9.9.9, 2.2.2.2"}, "2.2.2.2"},
+		// Via a chain of trusted proxies.
+		{"10.0.0.1", map[string]string{"X-Forwarded-For": "2.2.2.2, 10.0.0.3, 10.0.0.2"}, "2.2.2.2"},
+		// Invalid addresses to the left of trusted hops don't yield a trusted hop.
+		{"10.0.0.1", map[string]string{"X-Forwarded-For": "garbage, 10.0.0.2"}, "10.0.0.1"},
+		// An invalid right-most address doesn't fall back to a forgeable X-Real-IP.
+		{"10.0.0.1", map[string]string{"X-Forwarded-For": "2.2.2.2, garbage", "X-Real-IP": "3.3.3.3"}, "10.0.0.1"},
+		// A chain of only trusted proxies.
+		{"10.0.0.1", map[string]string{"X-Forwarded-For": "10.0.0.3, 10.0.0.2"}, "10.0.0.1"},
+		// X-Real-IP fallback.
+		{"10.0.0.1", map[string]string{"X-Real-IP": "3.3.3.3"}, "3.3.3.3"},
+		// No headers.
+		{"10.0.0.1", nil, "10.0.0.1"},
+	} {
+		require.Equal(t, c.exp, newReq(c.remote, c.headers).ClientIP(trusted), c)
+	}
+}
+
+func TestDecodeStrict(t *testing.T) {
+	newReq := func(ctype string, body string) *Request {
+		req := &Request{RequestCtx: &fasthttp.RequestCtx{}}
+		req.RequestCtx.Request.Header.SetMethod(fasthttp.MethodPost)
+		req.RequestCtx.Request.Header.SetContentType(ctype)
+		req.RequestCtx.Request.SetBody([]byte(body))
+		return req
+	}
+
+	var p Person
+	require.NoError(t, newReq(JSON, `{"name": "tester", "age": 30}`).DecodeStrict(&p, "json"))
+	require.Equal(t, Person{Name: "tester", Age: 30}, p)
+
+	p = Person{}
+	require.NoError(t, newReq("application/x-www-form-urlencoded", `name=tester&age=30`).DecodeStrict(&p, "json"))
+	require.Equal(t, Person{Name: "tester", Age: 30}, p)
+
+	// JSON sent as plain text is silently ignored by Decode but errors in strict mode.
+	require.NoError(t, newReq(PLAINTEXT, `{"name": "tester"}`).Decode(&p, "json"))
+	require.EqualError(t, newReq(PLAINTEXT, `{"name": "tester"}`).DecodeStrict(&p, "json"),
+		"error decoding request: unsupported content type `text/plain`, expected JSON, XML or form")
+}
+
+func TestDecodeWithQuery(t *testing.T) {
+	type item struct {
+		Name   string `json:"name"`
+		DryRun bool   `json:"dry_run"`
+	}
+
+	req := &Request{RequestCtx: &fasthttp.RequestCtx{}}
+	req.RequestCtx.Request.Header.SetMethod(fasthttp.MethodPost)
+	req.RequestCtx.Request.SetRequestURI("/items?dry_run=true")
+	req.RequestCtx.Request.Header.SetContentType(JSON)
+	req.RequestCtx.Request.SetBody([]byte(`{"name": "item"}`))
+
+	var i item
+	require.NoError(t, req.DecodeWithQuery(&i, "json"))
+	require.Equal(t, item{Name: "item", DryRun: true}, i)
+
+	// The query arg takes precedence over the body.
+	req.RequestCtx.Request.SetRequestURI("/items?dry_run=false")
+	req.RequestCtx.Request.SetBody([]byte(`{"name": "item", "dry_run": true}`))
+	i = item{}
+	require.NoError(t, req.DecodeWithQuery(&i, "json"))
+	require.Equal(t, item{Name: "item", DryRun: false}, i)
+}
+
+func TestShutdownProgress(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		counts []int
+	)
+
+	g := New()
+	g.OnShutdownProgress(func(active int) {
+		mu.Lock()
+		counts = append(counts, active)
+		mu.Unlock()
+	}, 50*time.Millisecond)
+	g.GET("/", func(r *Request) error {
+		time.Sleep(500 * time.Millisecond)
+		return r.SendEnvelope(true)
+	})
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	var (
+		ch   = make(chan struct{})
+		done = make(chan error, 1)
+	)
+	go func() {
+		done <- g.ServeGracefully(ln, nil, ch)
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	// Fire an in-flight request that shutdown has to wait for.
+	go func() {
+		if resp, err := http.Get("http://" + ln.Addr().String() + "/"); err == nil {
+			resp.Body.Close()
+		}
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	ch <- struct{}{}
+	require.NoError(t, <-done)
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.NotEmpty(t, counts)
+	require.Equal(t, 1, counts[0])
+}
+
+func TestMessageTranslator(t *testing.T) {
+	g := NewGlue()
+	g.SetMessageTranslator(func(lang, key string) string {
+		if lang == "fr" && key == "user.not_found" {
+			return "utilisateur introuvable"
+		}
+		return ""
+	})
+	g.GET("/", func(r *Request) error {
+		return r.SendErrorEnvelope(fasthttp.StatusNotFound, "user.not_found", nil, "")
+	})
+
+	for lang, msg := range map[string]string{
+		"fr;q=0.9, en;q=0.5": "utilisateur introuvable",
+		"en-US,fr;q=0.9":     "user.not_found",
+		"":                   "user.not_found",
+	} {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.SetRequestURI("/")
+		ctx.Request.Header.Set("Accept-Language", lang)
+		g.Handler()(ctx)
+
+		var e Envelope
+		require.NoError(t, json.Unmarshal(ctx.Response.Body(), &e))
+		require.Equal(t, msg, *e.Message, lang)
+	}
+}
+
+func TestBatchHandler(t *testing.T) {
+	g := NewGlue()
+	g.SetContext(&App{version: "xxx"})
+	g.Before(getParamMiddleware)
+	g.GET("/get", myGEThandler)
+	g.POST("/post_json", myPOSTJsonhandler)
+	g.BatchHandler("/batch")
+
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Request.Header.SetMethod(fasthttp.MethodPost)
+	ctx.Request.SetRequestURI("/batch?param=123")
+	ctx.Request.Header.SetContentType(JSON)
+	ctx.Request.SetBody([]byte(`[
+		{"method": "GET", "path": "/get?param=123&name=test"},
+		{"method": "POST", "path": "/post_json?param=123", "body": {"name": "tester", "age": 30}},
+		{"method": "GET", "path": "/get"},
+		{"method": "GET", "path": "/batch?param=123"}
+	]`))
+	g.Handler()(ctx)
+	require.Equal(t, fasthttp.StatusOK, ctx.Response.StatusCode())
+
+	var out struct {
+		Data []struct {
+			Status int      `json:"status"`
+			Body   Envelope `json:"body"`
+		} `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(ctx.Response.Body(), &out))
+	require.Len(t, out.Data, 4)
+
+	require.Equal(t, fasthttp.StatusOK, out.Data[0].Status)
+	require.Equal(t, map[string]interface{}{"out": "name=test"}, out.Data[0].Body.Data)
+
+	require.Equal(t, fasthttp.StatusOK, out.Data[1].Status)
+	require.Equal(t, "tester", out.Data[1].Body.Data.(map[string]interface{})["name"])
+	require.Equal(t, "xxx", out.Data[1].Body.Data.(map[string]interface{})["version"])
+
+	// Sub-requests run through the before middleware.
+	require.Equal(t, fasthttp.StatusBadRequest, out.Data[2].Status)
+
+	// Recursive batch requests are rejected.
+	require.Equal(t, fasthttp.StatusBadRequest, out.Data[3].Status)
+
+	// Sub-requests go through fastglue's handler (response hooks, maintenance mode)
+	// and don't inherit the batch request's Content-Encoding.
+	hooks := 0
+	g.OnResponse(func(r *Request, s int, b int) {
+		hooks++
+	})
+	g.SetMaintenance(true, []string{"/batch"})
+
+	ctx = &fasthttp.RequestCtx{}
+	ctx.Request.Header.SetMethod(fasthttp.MethodPost)
+	ctx.Request.SetRequestURI("/batch?param=123")
+	ctx.Request.Header.SetContentType(JSON)
+	ctx.Request.Header.Set(fasthttp.HeaderContentEncoding, "gzip")
+	ctx.Request.SetBody(fasthttp.AppendGzipBytes(nil, []byte(`[{"method": "GET", "path": "/get?param=123&name=test"}]`)))
+	g.Handler()(ctx)
+	require.Equal(t, fasthttp.StatusOK, ctx.Response.StatusCode())
+	require.NoError(t, json.Unmarshal(ctx.Response.Body(), &out))
+	require.Len(t, out.Data, 1)
+	require.Equal(t, fasthttp.StatusServiceUnavailable, out.Data[0].Status)
+	require.Equal(t, 2, hooks)
+
+	g.SetMaintenance(false, nil)
+	ctx = &fasthttp.RequestCtx{}
+	ctx.Request.Header.SetMethod(fasthttp.MethodPost)
+	ctx.Request.SetRequestURI("/batch?param=123")
+	ctx.Request.Header.SetContentType(JSON)
+	ctx.Request.Header.Set(fasthttp.HeaderContentEncoding, "gzip")
+	ctx.Request.SetBody(fasthttp.AppendGzipBytes(nil, []byte(`[{"method": "POST", "path": "/post_json?param=123", "body": {"name": "tester", "age": 30}}]`)))
+	g.Handler()(ctx)
+	require.NoError(t, json.Unmarshal(ctx.Response.Body(), &out))
+	require.Equal(t, fasthttp.StatusOK, out.Data[0].Status)
+
+	// Batches larger than the max batch size are rejected.
+	g.SetMaxBatchSize(1)
+	ctx = &fasthttp.RequestCtx{}
+	ctx.Request.Header.SetMethod(fasthttp.MethodPost)
+	ctx.Request.SetRequestURI("/batch?param=123")
+	ctx.Request.Header.SetContentType(JSON)
+	ctx.Request.SetBody([]byte(`[{"method": "GET", "path": "/get"}, {"method": "GET", "path": "/get"}]`))
+	g.Handler()(ctx)
+	require.Equal(t, fasthttp.StatusBadRequest, ctx.Response.StatusCode())
+
+	// Sub-requests release what they hold (eg: Concurrency slots) once they're done.
+	g = NewGlue()
+	g.Before(Concurrency(2, ConcurrencyOptions{}))
+	g.GET("/get", func(r *Request) error {
+		return r.SendEnvelope(true)
+	})
+	g.BatchHandler("/batch")
+	for i := 0; i < 5; i++ {
+		ctx = &fasthttp.RequestCtx{}
+		ctx.Request.Header.SetMethod(fasthttp.MethodPost)
+		ctx.Request.SetRequestURI("/batch")
+		ctx.Request.Header.SetContentType(JSON)
+		ctx.Request.SetBody([]byte(`[{"method": "GET", "path": "/get"}, {"method": "GET", "path": "/get"}]`))
+		g.Handler()(ctx)
+		require.Equal(t, fasthttp.StatusOK, ctx.Response.StatusCode())
+		require.NoError(t, json.Unmarshal(ctx.Response.Body(), &out))
+		for _, d := range out.Data {
+			require.Equal(t, fasthttp.StatusOK, d.Status, i)
+		}
+
+		// Release the batch request's own slot as fasthttp would.
+		ctx.ResetUserValues()
+	}
+}
+
+func TestRawBody(t *testing.T) {
+	secret := []byte("secret")
+	sign := func(b []byte) string {
+		h := hmac.New(sha256.New, secret)
+		h.Write(b)
+		return hex.EncodeToString(h.Sum(nil))
+	}
+
+	g := NewGlue()
+	g.SetContext(&App{version: "xxx"})
+	g.Before(CacheBody, func(r *Request) *Request {
+		sig, _ := hex.DecodeString(string(r.RequestCtx.Request.Header.Peek("X-Signature")))
+		exp, _ := hex.DecodeString(sign(r.RawBody()))
+		if !hmac.Equal(sig, exp) {
+			_ = r.SendErrorEnvelope(fasthttp.StatusUnauthorized, "invalid signature", nil, "")
+			return nil
+		}
+		return r
+	})
+	g.POST("/", myPOSTJsonhandler)
+
+	body := []byte(`{"name": "tester", "age": 30}`)
+	for sig, code := range map[string]int{
+		sign(body):                    fasthttp.StatusOK,
+		sign([]byte(`{"name": "x"}`)): fasthttp.StatusUnauthorized,
+	} {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.Header.SetMethod(fasthttp.MethodPost)
+		ctx.Request.SetRequestURI("/")
+		ctx.Request.Header.SetContentType(JSON)
+		ctx.Request.Header.Set("X-Signature", sig)
+		ctx.Request.SetBody(body)
+		g.Handler()(ctx)
+		require.Equal(t, code, ctx.Response.StatusCode())
+	}
+}
+
+func TestListenOnRandomPort(t *testing.T) {
+	g := NewGlue()
+	g.GET("/", func(r *Request) error {
+		return r.SendEnvelope("random")
+	})
+
+	addr, stop, err := g.ListenOnRandomPort()
+	require.NoError(t, err)
+
+	resp := GETrequest("http://"+addr+"/", t)
+	require.Equal(t, fasthttp.StatusOK, resp.StatusCode)
+	e, _ := decodeEnvelope(resp, t)
+	require.Equal(t, "random", e.Data)
+
+	stop()
+	_, err = http.Get("http://" + addr + "/")
+	require.Error(t, err)
+}
+
+func TestStdContext(t *testing.T) {
+	var (
+		g         = NewGlue()
+		started   = make(chan struct{})
+		cancelled = make(chan error, 1)
+	)
+	g.GET("/slow", func(r *Request) error {
+		ctx := r.StdContext()
+		require.Equal(t, ctx, r.StdContext())
+		close(started)
+
+		select {
+		case <-ctx.Done():
+			cancelled <- ctx.Err()
+		case <-time.After(3 * time.Second):
+			cancelled <- nil
+		}
+		return nil
+	})
+
+	addr, stop, err := g.ListenOnRandomPort()
+	require.NoError(t, err)
+	defer stop()
+
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	_, err = conn.Write([]byte("GET /slow HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+
+	// Close the client connection while the handler is running.
+	<-started
+	require.NoError(t, conn.Close())
+	require.Equal(t, context.Canceled, <-cancelled)
+
+	// Requests without a connection get a context that's never cancelled by it.
+	req := &Request{RequestCtx: &fasthttp.RequestCtx{}}
+	require.NoError(t, req.StdContext().Err())
+}
+
+func TestDeadline(t *testing.T) {
+	var (
+		g   = NewGlue()
+		out = make(chan time.Time, 1)
+	)
+	g.GET("/deadline", func(r *Request) error {
+		d, ok := r.Deadline()
+		require.True(t, ok)
+
+		// The request's StdContext carries the same deadline.
+		cd, ok := r.StdContext().Deadline()
+		require.True(t, ok)
+		require.Equal(t, d, cd)
+
+		out <- d
+		return r.SendEnvelope("ok")
+	})
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	s := &fasthttp.Server{WriteTimeout: 5 * time.Second}
+	go g.Serve(ln, s)
+	defer s.Shutdown()
+
+	start := time.Now()
+	resp := GETrequest("http://"+ln.Addr().String()+"/deadline", t)
+	require.Equal(t, fasthttp.StatusOK, resp.StatusCode)
+	require.WithinDuration(t, start.Add(5*time.Second), <-out, time.Second)
+
+	// No deadline without a WriteTimeout.
+	req := &Request{RequestCtx: &fasthttp.RequestCtx{}, glue: New()}
+	_, ok := req.Deadline()
+	require.False(t, ok)
+}
+
+func TestMultipartMaxMemory(t *testing.T) {
+	g := NewGlue()
+	g.MultipartMaxMemory = 1024
+
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	require.NoError(t, w.WriteField("name", "report"))
+	fw, err := w.CreateFormFile("small", "small.txt")
+	require.NoError(t, err)
+	_, err = fw.Write(bytes.Repeat([]byte("a"), 100))
+	require.NoError(t, err)
+	fw, err = w.CreateFormFile("large", "large.txt")
+	require.NoError(t, err)
+	_, err = fw.Write(bytes.Repeat([]byte("b"), 4096))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Request.Header.SetMethod(fasthttp.MethodPost)
+	ctx.Request.Header.SetContentType(w.FormDataContentType())
+	ctx.Request.SetBody(body.Bytes())
+	req := g.newRequest(ctx)
+
+	form, err := req.MultipartForm()
+	require.NoError(t, err)
+	require.Equal(t, []string{"report"}, form.Value["name"])
+
+	// The small file is held in memory.
+	f, err := form.File["small"][0].Open()
+	require.NoError(t, err)
+	_, isFile := f.(*os.File)
+	require.False(t, isFile)
+	f.Close()
+
+	// The large file exceeds the memory limit and is spilled to disk.
+	f, err = form.File["large"][0].Open()
+	require.NoError(t, err)
+	tmp, isFile := f.(*os.File)
+	require.True(t, isFile)
+	b, err := ioutil.ReadAll(f)
+	require.NoError(t, err)
+	require.Len(t, b, 4096)
+	f.Close()
+
+	// The form is parsed only once.
+	form2, err := req.MultipartForm()
+	require.NoError(t, err)
+	require.True(t, form == form2)
+
+	// Temporary files are removed once the request is done.
+	ctx.ResetUserValues()
+	_, err = os.Stat(tmp.Name())
+	require.True(t, os.IsNotExist(err))
+
+	// Non multipart requests.
+	ctx = &fasthttp.RequestCtx{}
+	_, err = g.newRequest(ctx).MultipartForm()
+	require.Equal(t, fasthttp.ErrNoMultipartForm, err)
+}
+
+func TestSendNoContent(t *testing.T) {
+	g := NewGlue()
+	g.DELETE("/item", func(r *Request) error {
+		r.RequestCtx.WriteString("discarded")
+		return r.SendNoContent()
+	})
+
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Request.Header.SetMethod(fasthttp.MethodDelete)
+	ctx.Request.SetRequestURI("/item")
+	g.Handler()(ctx)
+
+	require.Equal(t, fasthttp.StatusNoContent, ctx.Response.StatusCode())
+	require.Empty(t, ctx.Response.Body())
+}
+
+func TestSendEnvelopeCode(t *testing.T) {
+	g := NewGlue()
+	g.POST("/items", func(r *Request) error {
+		return r.SendEnvelopeCode(fasthttp.StatusCreated, map[string]int{"id": 1})
+	})
+	g.POST("/raw", func(r *Request) error {
+		return r.SendEnvelopeCode(fasthttp.StatusAccepted, json.RawMessage(`{"id": 2}`))
+	})
+
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Request.Header.SetMethod(fasthttp.MethodPost)
+	ctx.Request.SetRequestURI("/items")
+	g.Handler()(ctx)
+	require.Equal(t, fasthttp.StatusCreated, ctx.Response.StatusCode())
+	require.JSONEq(t, `{"status": "success", "data": {"id": 1}}`, string(ctx.Response.Body()))
+
+	ctx = &fasthttp.RequestCtx{}
+	ctx.Request.Header.SetMethod(fasthttp.MethodPost)
+	ctx.Request.SetRequestURI("/raw")
+	g.Handler()(ctx)
+	require.Equal(t, fasthttp.StatusAccepted, ctx.Response.StatusCode())
+	require.JSONEq(t, `{"status": "success", "data": {"id": 2}}`, string(ctx.Response.Body()))
+}
+
+func TestSetFallback(t *testing.T) {
+	g := NewGlue()
+	g.SetContext("app")
+	g.GET("/known", func(r *Request) error {
+		return r.SendEnvelope("known")
+	})
+	g.SetFallback(func(r *Request) error {
+		return r.SendString(fasthttp.StatusOK, fmt.Sprintf("%s %s %v",
+			r.RequestCtx.Method(), r.RequestCtx.Path(), r.Context))
+	})
+
+	req := func(method, uri string) *fasthttp.RequestCtx {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.Header.SetMethod(method)
+		ctx.Request.SetRequestURI(uri)
+		g.Handler()(ctx)
+		return ctx
+	}
+
+	ctx := req(fasthttp.MethodGet, "/proxy/a/b")
+	require.Equal(t, fasthttp.StatusOK, ctx.Response.StatusCode())
+	require.Equal(t, "GET /proxy/a/b app", string(ctx.Response.Body()))
+
+	// Unmatched methods on known paths also fall back.
+	ctx = req(fasthttp.MethodPost, "/known")
+	require.Equal(t, "POST /known app", string(ctx.Response.Body()))
+
+	ctx = req(fasthttp.MethodGet, "/known")
+	require.Contains(t, string(ctx.Response.Body()), "known")
+
+	// Without a fallback, the enveloped 404 is sent.
+	g.SetFallback(nil)
+	ctx = req(fasthttp.MethodGet, "/proxy/a/b")
+	require.Equal(t, fasthttp.StatusNotFound, ctx.Response.StatusCode())
+	require.Contains(t, string(ctx.Response.Body()), "Route not found")
+}
+
+// benchRequest prevents the compiler from optimising away allocations in benchmarks.
+var benchRequest *Request
+
+func BenchmarkRequest(b *testing.B) {
+	g := NewGlue()
+	ctx := &fasthttp.RequestCtx{}
+
+	b.Run("new", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			benchRequest = g.newRequest(ctx)
+		}
+	})
+
+	b.Run("pooled", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			benchRequest = g.acquireRequest(ctx)
+			releaseRequest(benchRequest)
+		}
+	})
+}
+
+func BenchmarkHandler(b *testing.B) {
+	g := NewGlue()
+	g.GET("/", func(r *Request) error {
+		return nil
+	})
+	h := g.Handler()
+
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Request.SetRequestURI("/")
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		h(ctx)
+	}
+}
+
+func TestAny(t *testing.T) {
+	req := func(g *Fastglue, method string) *fasthttp.RequestCtx {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.Header.SetMethod(method)
+		ctx.Request.SetRequestURI("/any")
+		g.Handler()(ctx)
+		return ctx
+	}
+	h := func(r *Request) error {
+		return r.SendString(fasthttp.StatusOK, "any")
+	}
+
+	g := NewGlue()
+	g.Any("/any", h)
+	for _, m := range []string{"GET", "POST", "PUT", "DELETE"} {
+		ctx := req(g, m)
+		require.Equal(t, fasthttp.StatusOK, ctx.Response.StatusCode(), m)
+		require.Equal(t, "any", string(ctx.Response.Body()), m)
+	}
+	require.Equal(t, fasthttp.StatusMethodNotAllowed, req(g, "PATCH").Response.StatusCode())
+
+	g = NewGlue()
+	g.SetAnyMethods("GET", "POST", "PUT", "DELETE", "PATCH", "OPTIONS", "HEAD")
+	g.Any("/any", h)
+	for _, m := range []string{"GET", "POST", "PUT", "DELETE", "PATCH", "OPTIONS", "HEAD"} {
+		ctx := req(g, m)
+		require.Equal(t, fasthttp.StatusOK, ctx.Response.StatusCode(), m)
+	}
+}
+
+func TestStreamEnvelopes(t *testing.T) {
+	g := NewGlue()
+	g.SetStreamEnvelopes(true)
+	g.GET("/stream", func(r *Request) error {
+		return r.SendEnvelope([]int{1, 2, 3})
+	})
+	g.GET("/bad", func(r *Request) error {
+		return r.SendEnvelope(map[string]interface{}{"ch": make(chan int)})
+	})
+
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Request.SetRequestURI("/stream")
+	g.Handler()(ctx)
+	require.Equal(t, fasthttp.StatusOK, ctx.Response.StatusCode())
+	require.Equal(t, JSON, string(ctx.Response.Header.ContentType()))
+	require.JSONEq(t, `{"status": "success", "data": [1, 2, 3]}`, string(ctx.Response.Body()))
+
+	ctx = &fasthttp.RequestCtx{}
+	ctx.Request.SetRequestURI("/bad")
+	g.Handler()(ctx)
+	require.Equal(t, fasthttp.StatusInternalServerError, ctx.Response.StatusCode())
+	require.Contains(t, string(ctx.Response.Body()), "Couldn't marshal JSON")
+}
+
+func BenchmarkSendEnvelope(b *testing.B) {
+	type item struct {
+		ID   int    `json:"id"`
+		Name string `json:"name"`
+	}
+	data := make([]item, 10000)
+	for i := range data {
+		data[i] = item{ID: i, Name: "item"}
+	}
+
+	for _, stream := range []bool{false, true} {
+		g := NewGlue()
+		g.SetStreamEnvelopes(stream)
+
+		b.Run(fmt.Sprintf("stream=%v", stream), func(b *testing.B) {
+			ctx := &fasthttp.RequestCtx{}
+			r := g.newRequest(ctx)
+
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				ctx.Response.Reset()
+				if err := r.SendEnvelope(data); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestCacheControl(t *testing.T) {
+	req := &Request{RequestCtx: &fasthttp.RequestCtx{}}
+
+	req.SetCacheControl(time.Hour, true)
+	require.Equal(t, "public, max-age=3600", string(req.RequestCtx.Response.Header.Peek("Cache-Control")))
+
+	req.SetCacheControl(time.Minute, false)
+	require.Equal(t, "private, max-age=60", string(req.RequestCtx.Response.Header.Peek("Cache-Control")))
+
+	req.NoStore()
+	require.Equal(t, "no-store", string(req.RequestCtx.Response.Header.Peek("Cache-Control")))
+}
+
+func TestSendEnvelopeMarshalError(t *testing.T) {
+	// Unmarshallable success data.
+	req := &Request{RequestCtx: &fasthttp.RequestCtx{}}
+	require.NoError(t, req.SendEnvelope(make(chan int)))
+	require.Equal(t, fasthttp.StatusInternalServerError, req.RequestCtx.Response.StatusCode())
+	require.True(t, json.Valid(req.RequestCtx.Response.Body()))
+	require.Contains(t, string(req.RequestCtx.Response.Body()), "Couldn't marshal JSON")
+
+	// Unmarshallable error data.
+	req = &Request{RequestCtx: &fasthttp.RequestCtx{}}
+	require.NoError(t, req.SendErrorEnvelope(fasthttp.StatusBadRequest, "error", make(chan int), excepBadRequest))
+	require.Equal(t, fasthttp.StatusInternalServerError, req.RequestCtx.Response.StatusCode())
+	require.JSONEq(t, `{"status": "error", "message": "Internal server error", "error_type": "GeneralException", "data": null}`,
+		string(req.RequestCtx.Response.Body()))
+}
+
+func TestDecodeFailWith(t *testing.T) {
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Request.Header.SetContentType(JSON)
+	ctx.Request.SetBodyString(`{"bad"`)
+	req := &Request{RequestCtx: ctx}
+
+	var v map[string]interface{}
+	require.Error(t, req.DecodeFailWith(&v, "json", "ValidationException", "Invalid order"))
+	require.Equal(t, fasthttp.StatusBadRequest, ctx.Response.StatusCode())
+
+	var e Envelope
+	require.NoError(t, json.Unmarshal(ctx.Response.Body(), &e))
+	require.Equal(t, ErrorType("ValidationException"), *e.ErrorType)
+	require.True(t, strings.HasPrefix(*e.Message, "Invalid order: `"))
+}
+
+func TestWithContext(t *testing.T) {
+	type tenant struct {
+		app  *App
+		name string
+	}
+
+	app := &App{version: "v1.2.3"}
+	g := NewGlue()
+	g.SetContext(app)
+	g.Before(func(r *Request) *Request {
+		name := string(r.RequestCtx.Request.Header.Peek("X-Tenant"))
+		if name == "" {
+			return r
+		}
+		return r.WithContext(&tenant{app: r.Context.(*App), name: name})
+	})
+	g.GET("/", func(r *Request) error {
+		switch c := r.Context.(type) {
+		case *tenant:
+			return r.SendString(fasthttp.StatusOK, c.name+"@"+c.app.version)
+		case *App:
+			return r.SendString(fasthttp.StatusOK, c.version)
+		}
+		return nil
+	})
+
+	get := func(name string) string {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.SetRequestURI("/")
+		if name != "" {
+			ctx.Request.Header.Set("X-Tenant", name)
+		}
+		g.Handler()(ctx)
+		return string(ctx.Response.Body())
+	}
+
+	require.Equal(t, "acme@v1.2.3", get("acme"))
+	require.Equal(t, "zen@v1.2.3", get("zen"))
+
+	// The derived context doesn't leak into other requests or the global context.
+	require.Equal(t, "v1.2.3", get(""))
+	require.Equal(t, app, g.context)
+}
+
+func TestSetJSONContentType(t *testing.T) {
+	const ct = "application/vnd.api+json"
+
+	g := NewGlue()
+	g.Before(func(r *Request) *Request {
+		r.SetJSONContentType(ct)
+		return r
+	})
+	g.GET("/ok", func(r *Request) error {
+		return r.SendEnvelope("ok")
+	})
+	g.GET("/raw", func(r *Request) error {
+		return r.SendEnvelope(json.RawMessage(`"ok"`))
+	})
+	g.GET("/err", func(r *Request) error {
+		return r.SendErrorEnvelope(fasthttp.StatusBadRequest, "bad", nil, excepBadRequest)
+	})
+
+	for _, p := range []string{"/ok", "/raw", "/err"} {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.SetRequestURI(p)
+		g.Handler()(ctx)
+		require.Equal(t, ct, string(ctx.Response.Header.ContentType()), p)
+	}
+
+	// Other requests aren't affected.
+	r := &Request{RequestCtx: &fasthttp.RequestCtx{}}
+	require.NoError(t, r.SendJSON(fasthttp.StatusOK, true))
+	require.Equal(t, JSON, string(r.RequestCtx.Response.Header.ContentType()))
+}
+
+func TestDecodeErrorUserValue(t *testing.T) {
+	var logged interface{}
+
+	g := NewGlue()
+	g.After(func(r *Request) *Request {
+		logged = r.RequestCtx.UserValue(UserValueDecodeError)
+		return r
+	})
+	g.POST("/orders", func(r *Request) error {
+		var p Person
+		if err := r.DecodeFail(&p, "json"); err != nil {
+			return nil
+		}
+		return r.SendEnvelope(p)
+	})
+
+	post := func(body string) *fasthttp.RequestCtx {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.Header.SetMethod(fasthttp.MethodPost)
+		ctx.Request.Header.SetContentType(JSON)
+		ctx.Request.SetRequestURI("/orders")
+		ctx.Request.SetBodyString(body)
+		g.Handler()(ctx)
+		return ctx
+	}
+
+	post(`{"name": "tester", "age": "thirty"}`)
+	err, ok := logged.(error)
+	require.True(t, ok)
+	var de *DecodeError
+	require.True(t, errors.As(err, &de))
+	require.Equal(t, "age", de.Field)
+
+	post(`{"name": "tester"}`)
+	require.Nil(t, logged)
+
+	// The error handler sets decoding errors returned by handlers.
+	g.POST("/raw", func(r *Request) error {
+		var p Person
+		return r.Decode(&p, "json")
+	})
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Request.Header.SetMethod(fasthttp.MethodPost)
+	ctx.Request.Header.SetContentType(JSON)
+	ctx.Request.SetRequestURI("/raw")
+	ctx.Request.SetBodyString(`{"age": "x"}`)
+	g.Handler()(ctx)
+	require.Equal(t, fasthttp.StatusInternalServerError, ctx.Response.StatusCode())
+	_, ok = ctx.UserValue(UserValueDecodeError).(*DecodeError)
+	require.True(t, ok)
+}
+
+func TestElapsed(t *testing.T) {
+	var (
+		g   = NewGlue()
+		out = make(chan [2]time.Duration, 1)
+	)
+	g.GET("/sleep", func(r *Request) error {
+		before := r.Elapsed()
+		time.Sleep(50 * time.Millisecond)
+		out <- [2]time.Duration{before, r.Elapsed()}
+		return r.SendEnvelope("ok")
+	})
+
+	addr, stop, err := g.ListenOnRandomPort()
+	require.NoError(t, err)
+	defer stop()
+
+	resp := GETrequest("http://"+addr+"/sleep", t)
+	require.Equal(t, fasthttp.StatusOK, resp.StatusCode)
+
+	e := <-out
+	require.True(t, e[1]-e[0] >= 50*time.Millisecond)
+
+	// Unknown start time.
+	req := &Request{RequestCtx: &fasthttp.RequestCtx{}}
+	require.Equal(t, time.Duration(0), req.Elapsed())
+}
+
+func TestRedirectWithCookies(t *testing.T) {
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Request.SetRequestURI("/login")
+	req := &Request{RequestCtx: ctx}
+
+	session := &fasthttp.Cookie{}
+	session.SetKey("session")
+	session.SetValue("abc")
+	session.SetHTTPOnly(true)
+	session.SetSecure(true)
+
+	strict := &fasthttp.Cookie{}
+	strict.SetKey("pref")
+	strict.SetValue("dark")
+	strict.SetSameSite(fasthttp.CookieSameSiteStrictMode)
+
+	require.NoError(t, req.RedirectWithCookies("/dashboard", fasthttp.StatusFound, []*fasthttp.Cookie{session, strict}))
+	require.Equal(t, fasthttp.StatusFound, ctx.Response.StatusCode())
+	require.True(t, strings.HasSuffix(string(ctx.Response.Header.Peek("Location")), "/dashboard"))
+
+	c := &fasthttp.Cookie{}
+	c.SetKey("session")
+	require.True(t, ctx.Response.Header.Cookie(c))
+	require.Equal(t, "abc", string(c.Value()))
+	require.Equal(t, fasthttp.CookieSameSiteLaxMode, c.SameSite())
+	require.True(t, c.HTTPOnly())
+	require.True(t, c.Secure())
+
+	c.SetKey("pref")
+	require.True(t, ctx.Response.Header.Cookie(c))
+	require.Equal(t, fasthttp.CookieSameSiteStrictMode, c.SameSite())
+
+	// The caller's cookies aren't modified.
+	require.Equal(t, fasthttp.CookieSameSiteDisabled, session.SameSite())
+}
+
+func TestNotFoundContext(t *testing.T) {
+	g := NewGlue()
+	g.SetContext(&App{version: "v1.2.3"})
+	g.ResponseHeaders(func(r *Request) {
+		r.RequestCtx.Response.Header.Set("X-Version", r.Context.(*App).version)
+	})
+	g.GET("/exists", func(r *Request) error {
+		return r.SendEnvelope("ok")
+	})
+	g.SetMessageTranslator(func(lang, key string) string {
+		if lang == "fr" && key == "Route not found" {
+			return "Route introuvable"
+		}
+		return ""
+	})
+
+	req := func(method, uri string) *fasthttp.RequestCtx {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.Header.SetMethod(method)
+		ctx.Request.Header.Set("Accept-Language", "fr")
+		ctx.Request.SetRequestURI(uri)
+		g.Handler()(ctx)
+		return ctx
+	}
+
+	ctx := req(fasthttp.MethodGet, "/missing")
+	require.Equal(t, fasthttp.StatusNotFound, ctx.Response.StatusCode())
+	require.Equal(t, "v1.2.3", string(ctx.Response.Header.Peek("X-Version")))
+	require.Contains(t, string(ctx.Response.Body()), "Route introuvable")
+
+	ctx = req(fasthttp.MethodPost, "/exists")
+	require.Equal(t, fasthttp.StatusMethodNotAllowed, ctx.Response.StatusCode())
+	require.Equal(t, "v1.2.3", string(ctx.Response.Header.Peek("X-Version")))
+
+	ctx = req(fasthttp.MethodGet, "/exists")
+	require.Equal(t, fasthttp.StatusOK, ctx.Response.StatusCode())
+	require.Equal(t, "v1.2.3", string(ctx.Response.Header.Peek("X-Version")))
+}
+
+func TestHandle(t *testing.T) {
+	g := NewGlue()
+	g.Handle([]string{"GET", "POST"}, []string{"/ping", "/v1/ping"}, func(r *Request) error {
+		return r.SendString(fasthttp.StatusOK, "pong")
+	})
+
+	for _, m := range []string{"GET", "POST"} {
+		for _, p := range []string{"/ping", "/v1/ping"} {
+			ctx := &fasthttp.RequestCtx{}
+			ctx.Request.Header.SetMethod(m)
+			ctx.Request.SetRequestURI(p)
+			g.Handler()(ctx)
+			require.Equal(t, fasthttp.StatusOK, ctx.Response.StatusCode(), m+p)
+			require.Equal(t, "pong", string(ctx.Response.Body()), m+p)
+		}
+	}
+
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Request.Header.SetMethod("PUT")
+	ctx.Request.SetRequestURI("/ping")
+	g.Handler()(ctx)
+	require.Equal(t, fasthttp.StatusMethodNotAllowed, ctx.Response.StatusCode())
+}
+
+func TestQueryGetters(t *testing.T) {
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Request.SetRequestURI("/?name=test&empty=&page=2&badpage=two&price=10.5&badprice=NaN&active=true&badactive=yes")
+	req := &Request{RequestCtx: ctx}
+
+	require.Equal(t, "test", req.QueryString("name", "def"))
+	require.Equal(t, "", req.QueryString("empty", "def"))
+	require.Equal(t, "def", req.QueryString("missing", "def"))
+
+	require.Equal(t, 2, req.QueryInt("page", 1))
+	require.Equal(t, 1, req.QueryInt("badpage", 1))
+	require.Equal(t, 1, req.QueryInt("missing", 1))
+
+	require.Equal(t, 10.5, req.QueryFloat("price", 1.5))
+	require.Equal(t, 1.5, req.QueryFloat("badprice", 1.5))
+	require.Equal(t, 1.5, req.QueryFloat("missing", 1.5))
+
+	require.Equal(t, true, req.QueryBool("active", false))
+	require.Equal(t, false, req.QueryBool("badactive", false))
+	require.Equal(t, true, req.QueryBool("missing", true))
+}
+
+func TestFormGetters(t *testing.T) {
+	g := NewGlue()
+	g.POST("/form", func(r *Request) error {
+		return r.SendEnvelope(map[string]interface{}{
+			"name":      r.FormString("name", "def"),
+			"missing":   r.FormString("missing", "def"),
+			"qty":       r.FormInt("qty", 1),
+			"badqty":    r.FormInt("badqty", 1),
+			"price":     r.FormFloat("price", 1.5),
+			"badprice":  r.FormFloat("badprice", 1.5),
+			"active":    r.FormBool("active", false),
+			"badactive": r.FormBool("badactive", true),
+		})
+	})
+
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Request.Header.SetMethod(fasthttp.MethodPost)
+	ctx.Request.Header.SetContentType("application/x-www-form-urlencoded")
+	ctx.Request.SetRequestURI("/form")
+	ctx.Request.SetBodyString("name=test&qty=5&badqty=five&price=99.9&badprice=abc&active=1&badactive=nope")
+	g.Handler()(ctx)
+
+	require.Equal(t, fasthttp.StatusOK, ctx.Response.StatusCode())
+	require.JSONEq(t, `{"status": "success", "data": {"name": "test", "missing": "def", "qty": 5, "badqty": 1,
+		"price": 99.9, "badprice": 1.5, "active": true, "badactive": true}}`, string(ctx.Response.Body()))
+}
+
+func TestFormQueryMap(t *testing.T) {
+	var form, query map[string][]string
+
+	g := NewGlue()
+	g.POST("/form", func(r *Request) error {
+		form = r.FormMap()
+		query = r.QueryMap()
+		return r.SendEnvelope(true)
+	})
+
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Request.Header.SetMethod(fasthttp.MethodPost)
+	ctx.Request.Header.SetContentType("application/x-www-form-urlencoded")
+	ctx.Request.SetRequestURI("/form?page=2&tag=a&tag=b")
+	ctx.Request.SetBodyString("name=test&symbol=INFY&symbol=TCS&symbol=&empty=")
+	g.Handler()(ctx)
+
+	require.Equal(t, fasthttp.StatusOK, ctx.Response.StatusCode())
+	require.Equal(t, map[string][]string{
+		"name":   {"test"},
+		"symbol": {"INFY", "TCS", ""},
+		"empty":  {""},
+	}, form)
+	require.Equal(t, map[string][]string{
+		"page": {"2"},
+		"tag":  {"a", "b"},
+	}, query)
+
+	// The maps are snapshots that outlive the request.
+	ctx.Request.Reset()
+	require.Equal(t, []string{"INFY", "TCS", ""}, form["symbol"])
+
+	r := &Request{RequestCtx: &fasthttp.RequestCtx{}}
+	require.Empty(t, r.FormMap())
+	require.Empty(t, r.QueryMap())
+}
+
+func TestDecodeStrictJSON(t *testing.T) {
+	type order struct {
+		Symbol string `json:"symbol"`
+		Qty    int    `json:"qty"`
+	}
+
+	req := func(body string) *Request {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.Header.SetContentType(JSON)
+		ctx.Request.SetBodyString(body)
+		return &Request{RequestCtx: ctx}
+	}
+
+	var o order
+	require.NoError(t, req(`{"symbol": "INFY", "qty": 10}`).DecodeStrictJSON(&o))
+	require.Equal(t, order{Symbol: "INFY", Qty: 10}, o)
+
+	err := req(`{"symbol": "INFY", "quantity": 10}`).DecodeStrictJSON(&o)
+	require.EqualError(t, err, `error decoding request: unknown field "quantity"`)
+
+	require.Error(t, req(`{"symbol": "INFY"} {}`).DecodeStrictJSON(&o))
+	require.Error(t, req(`{"symbol": "INFY"}}`).DecodeStrictJSON(&o))
+	require.Error(t, req(`{"symbol": "INFY"}]`).DecodeStrictJSON(&o))
+	require.NoError(t, req("{\"symbol\": \"INFY\"} \n\t").DecodeStrictJSON(&o))
+	require.Error(t, req(`{"symbol": 1}`).DecodeStrictJSON(&o))
+}
+
+func TestSetMaintenance(t *testing.T) {
+	g := NewGlue()
+	for _, p := range []string{"/health", "/orders"} {
+		g.GET(p, func(r *Request) error {
+			return r.SendEnvelope("ok")
+		})
+	}
+
+	req := func(uri string) int {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.SetRequestURI(uri)
+		g.Handler()(ctx)
+		return ctx.Response.StatusCode()
+	}
+
+	require.Equal(t, fasthttp.StatusOK, req("/orders"))
+
+	g.SetMaintenance(true, []string{"/health"})
+	require.Equal(t, fasthttp.StatusOK, req("/health"))
+	require.Equal(t, fasthttp.StatusServiceUnavailable, req("/orders"))
+	require.Equal(t, fasthttp.StatusServiceUnavailable, req("/missing"))
+
+	g.SetMaintenance(false, nil)
+	require.Equal(t, fasthttp.StatusOK, req("/orders"))
+
+	// Toggling concurrently with requests is safe.
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func(on bool) {
+			defer wg.Done()
+			g.SetMaintenance(on, []string{"/health"})
+		}(i%2 == 0)
+		go func() {
+			defer wg.Done()
+			req("/health")
+		}()
+	}
+	wg.Wait()
+}
+
+func TestSendJSONModified(t *testing.T) {
+	modTime := time.Date(2024, 1, 2, 3, 4, 5, 600, time.UTC)
+
+	g := NewGlue()
+	g.GET("/report", func(r *Request) error {
+		return r.SendJSONModified(fasthttp.StatusOK, map[string]int{"total": 10}, modTime)
+	})
+	g.GET("/missing", func(r *Request) error {
+		return r.SendJSONModified(fasthttp.StatusNotFound, map[string]string{"error": "not found"}, modTime)
+	})
+
+	get := func(uri, ims string) *fasthttp.RequestCtx {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.SetRequestURI(uri)
+		if ims != "" {
+			ctx.Request.Header.Set("If-Modified-Since", ims)
+		}
+		g.Handler()(ctx)
+		return ctx
+	}
+	req := func(ims string) *fasthttp.RequestCtx {
+		return get("/report", ims)
+	}
+
+	ctx := req("")
+	require.Equal(t, fasthttp.StatusOK, ctx.Response.StatusCode())
+	require.JSONEq(t, `{"total": 10}`, string(ctx.Response.Body()))
+	lastMod := string(ctx.Response.Header.Peek("Last-Modified"))
+	require.Equal(t, "Tue, 02 Jan 2024 03:04:05 GMT", lastMod)
+
+	// Not modified since.
+	ctx = req(lastMod)
+	require.Equal(t, fasthttp.StatusNotModified, ctx.Response.StatusCode())
+	require.Empty(t, ctx.Response.Body())
+
+	// Modified since an older time.
+	ctx = req("Mon, 01 Jan 2024 00:00:00 GMT")
+	require.Equal(t, fasthttp.StatusOK, ctx.Response.StatusCode())
+
+	// Non-2xx responses are never turned into a 304.
+	ctx = get("/missing", lastMod)
+	require.Equal(t, fasthttp.StatusNotFound, ctx.Response.StatusCode())
+	require.JSONEq(t, `{"error": "not found"}`, string(ctx.Response.Body()))
+	require.Empty(t, ctx.Response.Header.Peek("Last-Modified"))
+}
+
+func TestDecodeError(t *testing.T) {
+	type item struct {
+		Qty int `json:"qty"`
+	}
+	type order struct {
+		Items []item `json:"items"`
+	}
+
+	req := func(body string) *Request {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.Header.SetContentType(JSON)
+		ctx.Request.SetBodyString(body)
+		return &Request{RequestCtx: ctx}
+	}
+
+	// Type mismatch.
+	r := req(`{"items": [{"qty": "ten"}]}`)
+	var o order
+	err := r.DecodeFail(&o, "json")
+	var de *DecodeError
+	require.True(t, errors.As(err, &de))
+	require.Equal(t, "items.0.qty", de.Field)
+	require.True(t, strings.HasPrefix(err.Error(), "error decoding request: json: cannot unmarshal"))
+
+	var e struct {
+		Data DecodeError `json:"data"`
+	}
+	require.Equal(t, fasthttp.StatusBadRequest, r.RequestCtx.Response.StatusCode())
+	require.NoError(t, json.Unmarshal(r.RequestCtx.Response.Body(), &e))
+	require.Equal(t, "items.0.qty", e.Data.Field)
+	require.Equal(t, de.Offset, e.Data.Offset)
+	require.Equal(t, "expected int, got string", e.Data.Message)
+
+	// Syntax error.
+	r = req(`{"items": [}`)
+	err = r.DecodeFail(&o, "json")
+	require.True(t, errors.As(err, &de))
+	require.Empty(t, de.Field)
+	require.Equal(t, int64(12), de.Offset)
+}
+
+// tracePlugin records the phases of a request.
+type tracePlugin struct {
+	phases []string
+}
+
+func (p *tracePlugin) Before(r *Request) *Request {
+	p.phases = append(p.phases, "before")
+	return r
+}
+
+func (p *tracePlugin) After(r *Request) *Request {
+	p.phases = append(p.phases, "after")
+	return r
+}
+
+func TestUse(t *testing.T) {
+	p := &tracePlugin{}
+
+	g := NewGlue()
+	g.Use(p)
+	g.GET("/", func(r *Request) error {
+		p.phases = append(p.phases, "handler")
+		return r.SendEnvelope("ok")
+	})
+
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Request.SetRequestURI("/")
+	g.Handler()(ctx)
+
+	require.Equal(t, fasthttp.StatusOK, ctx.Response.StatusCode())
+	require.Equal(t, []string{"before", "handler", "after"}, p.phases)
+}
+
+func TestConfigureServer(t *testing.T) {
+	g := NewGlue()
+	g.ConfigureServer(func(s *fasthttp.Server) {
+		s.Name = "configured"
+		s.Concurrency = 100
+	})
+	g.GET("/", func(r *Request) error {
+		return r.SendEnvelope("ok")
+	})
+
+	addr, stop, err := g.ListenOnRandomPort()
+	require.NoError(t, err)
+	defer stop()
+
+	require.Equal(t, 100, g.Server.Concurrency)
+
+	resp := GETrequest("http://"+addr+"/", t)
+	require.Equal(t, fasthttp.StatusOK, resp.StatusCode)
+	require.Equal(t, "configured", resp.Header.Get("Server"))
+}
+
+func TestConfigureServerGracefully(t *testing.T) {
+	serve := map[string]func(g *Fastglue, ch chan struct{}) error{
+		"ListenServeAndWaitGracefully": func(g *Fastglue, ch chan struct{}) error {
+			return g.ListenServeAndWaitGracefully("127.0.0.1:0", "", nil, ch)
+		},
+		"ServeGracefully": func(g *Fastglue, ch chan struct{}) error {
+			ln, err := net.Listen("tcp", "127.0.0.1:0")
+			require.NoError(t, err)
+			return g.ServeGracefully(ln, nil, ch)
+		},
+	}
+
+	for name, fn := range serve {
+		var (
+			g     = NewGlue()
+			calls int32
+			ch    = make(chan struct{}, 1)
+			done  = make(chan error, 1)
+		)
+		g.ConfigureServer(func(s *fasthttp.Server) {
+			atomic.AddInt32(&calls, 1)
+		})
+
+		go func() {
+			done <- fn(g, ch)
+		}()
+		time.Sleep(100 * time.Millisecond)
+		ch <- struct{}{}
+		require.NoError(t, <-done, name)
+
+		// The server is configured once.
+		require.Equal(t, int32(1), atomic.LoadInt32(&calls), name)
+	}
+}
+
+func TestSetRedirectTrailingSlash(t *testing.T) {
+	g := NewGlue()
+	g.POST("/path", func(r *Request) error {
+		return r.SendEnvelope("ok")
+	})
+
+	req := func() *fasthttp.RequestCtx {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.Header.SetMethod(fasthttp.MethodPost)
+		ctx.Request.SetRequestURI("/path/")
+		g.Handler()(ctx)
+		return ctx
+	}
+
+	// Redirected by default.
+	ctx := req()
+	require.Equal(t, fasthttp.StatusPermanentRedirect, ctx.Response.StatusCode())
+
+	g.SetRedirectTrailingSlash(false)
+	ctx = req()
+	require.Equal(t, fasthttp.StatusNotFound, ctx.Response.StatusCode())
+}
+
+func TestSetCaseInsensitivePaths(t *testing.T) {
+	g := NewGlue()
+	g.GET("/get/{id}", func(r *Request) error {
+		return r.SendString(fasthttp.StatusOK, r.Param("id"))
+	})
+	g.GET("/files/{dir:[a-z]+}-{n}/{path:*}", func(r *Request) error {
+		return r.SendString(fasthttp.StatusOK, r.Param("dir")+" "+r.Param("n")+" "+r.Param("path"))
+	})
+
+	req := func(uri string) *fasthttp.RequestCtx {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.SetRequestURI(uri)
+		g.Handler()(ctx)
+		return ctx
+	}
+
+	// Mixed case paths don't match by default.
+	require.NotEqual(t, fasthttp.StatusOK, req("/GET/ABC").Response.StatusCode())
+
+	g.SetCaseInsensitivePaths(true)
+	ctx := req("/GET/ABC")
+	require.Equal(t, fasthttp.StatusOK, ctx.Response.StatusCode())
+	require.Equal(t, "ABC", string(ctx.Response.Body()))
+	require.Equal(t, "/get/abc", string(ctx.Path()))
+
+	ctx = req("/get/abc")
+	require.Equal(t, fasthttp.StatusOK, ctx.Response.StatusCode())
+	require.Equal(t, "abc", string(ctx.Response.Body()))
+
+	ctx = req("/Files/Docs-X1/Sub/ReadMe.MD")
+	require.Equal(t, fasthttp.StatusOK, ctx.Response.StatusCode())
+	require.Equal(t, "Docs X1 Sub/ReadMe.MD", string(ctx.Response.Body()))
+}
+
+func TestSetMaxResponseSize(t *testing.T) {
+	for _, stream := range []bool{false, true} {
+		g := NewGlue()
+		g.SetMaxResponseSize(1024)
+		g.SetStreamEnvelopes(stream)
+		g.GET("/small", func(r *Request) error {
+			return r.SendEnvelope(make([]int, 10))
+		})
+		g.GET("/large", func(r *Request) error {
+			err := r.SendEnvelope(make([]int, 10000))
+			require.True(t, errors.Is(err, ErrResponseTooLarge))
+			return err
+		})
+		g.GET("/large-json", func(r *Request) error {
+			return r.SendJSON(fasthttp.StatusOK, make([]int, 10000))
+		})
+		g.GET("/large-error", func(r *Request) error {
+			err := r.SendErrorEnvelope(fasthttp.StatusBadRequest, "Invalid items", make([]int, 10000), excepBadRequest)
+			require.True(t, errors.Is(err, ErrResponseTooLarge))
+			return nil
+		})
+
+		req := func(uri string) *fasthttp.RequestCtx {
+			ctx := &fasthttp.RequestCtx{}
+			ctx.Request.SetRequestURI(uri)
+			g.Handler()(ctx)
+			return ctx
+		}
+
+		require.Equal(t, fasthttp.StatusOK, req("/small").Response.StatusCode())
+
+		for _, uri := range []string{"/large", "/large-json", "/large-error"} {
+			ctx := req(uri)
+			require.Equal(t, fasthttp.StatusInternalServerError, ctx.Response.StatusCode(), uri)
+			require.JSONEq(t, `{"status": "error", "message": "Response too large", "error_type": "GeneralException", "data": null}`,
+				string(ctx.Response.Body()), uri)
+		}
+	}
+}
+
+func TestOnStop(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	var (
+		g       = NewGlue()
+		served  = make(chan struct{})
+		drained int32
+		order   []string
+		errDB   = errors.New("error closing db")
+		errLogs = errors.New("error flushing logs")
+	)
+	g.GET("/slow", func(r *Request) error {
+		close(served)
+		time.Sleep(300 * time.Millisecond)
+		atomic.StoreInt32(&drained, 1)
+		return r.SendEnvelope(true)
+	})
+	g.OnStop(func() error {
+		// The in-flight request has finished by the time hooks run.
+		require.Equal(t, int32(1), atomic.LoadInt32(&drained))
+		order = append(order, "db")
+		return errDB
+	}, func() error {
+		order = append(order, "cache")
+		return nil
+	}, func() error {
+		order = append(order, "logs")
+		return errLogs
+	})
+
+	var (
+		ch   = make(chan struct{})
+		done = make(chan error, 1)
+	)
+	go func() {
+		done <- g.ServeGracefully(ln, nil, ch)
+	}()
+
+	go http.Get("http://" + ln.Addr().String() + "/slow")
+	<-served
+	ch <- struct{}{}
+
+	select {
+	case err := <-done:
+		require.True(t, errors.Is(err, errDB))
+		require.True(t, errors.Is(err, errLogs))
+		require.Equal(t, "error closing db\nerror flushing logs", err.Error())
+	case <-time.After(3 * time.Second):
+		t.Fatal("server didn't shutdown")
+	}
+	require.Equal(t, []string{"db", "cache", "logs"}, order)
+}
+
+func TestSetMethodOverride(t *testing.T) {
+	g := NewGlue()
+	g.DELETE("/items/{id}", func(r *Request) error {
+		return r.SendString(fasthttp.StatusOK, "deleted "+r.Param("id"))
+	})
+	g.POST("/items/{id}", func(r *Request) error {
+		return r.SendString(fasthttp.StatusOK, "posted "+r.Param("id"))
+	})
+
+	req := func(header, form string) string {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.Header.SetMethod(fasthttp.MethodPost)
+		ctx.Request.SetRequestURI("/items/1")
+		if header != "" {
+			ctx.Request.Header.Set("X-HTTP-Method-Override", header)
+		}
+		if form != "" {
+			ctx.Request.Header.SetContentType("application/x-www-form-urlencoded")
+			ctx.Request.SetBodyString(form)
+		}
+		g.Handler()(ctx)
+		return string(ctx.Response.Body())
+	}
+
+	// Disabled by default.
+	require.Equal(t, "posted 1", req("DELETE", ""))
+
+	g.SetMethodOverride(true)
+	require.Equal(t, "deleted 1", req("DELETE", ""))
+	require.Equal(t, "deleted 1", req("", "_method=delete"))
+	require.Equal(t, "posted 1", req("", ""))
+
+	// Only PUT, PATCH and DELETE are allowed.
+	require.Equal(t, "posted 1", req("CONNECT", ""))
+}
+
+func TestDecodeCompressed(t *testing.T) {
+	type item struct {
+		Name string `json:"name"`
+		Qty  int    `json:"qty"`
+	}
+	body := []byte(`{"name": "pen", "qty": 2}`)
+
+	for enc, b := range map[string][]byte{
+		"gzip":    fasthttp.AppendGzipBytes(nil, body),
+		"deflate": fasthttp.AppendDeflateBytes(nil, body),
+		"br":      fasthttp.AppendBrotliBytes(nil, body),
+	} {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.Header.SetMethod(fasthttp.MethodPost)
+		ctx.Request.Header.SetContentType("application/json")
+		ctx.Request.Header.Set(fasthttp.HeaderContentEncoding, enc)
+		ctx.Request.SetBody(b)
+		r := &Request{RequestCtx: ctx}
+
+		var v item
+		require.NoError(t, r.Decode(&v, "json"), enc)
+		require.Equal(t, item{Name: "pen", Qty: 2}, v, enc)
+
+		// The body is decompressed in place.
+		raw, err := r.Body()
+		require.NoError(t, err)
+		require.Equal(t, body, raw)
+	}
+
+	// Corrupt and unsupported bodies.
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Request.Header.SetContentType("application/json")
+	ctx.Request.Header.Set(fasthttp.HeaderContentEncoding, "gzip")
+	ctx.Request.SetBody(body)
+	var v item
+	err := (&Request{RequestCtx: ctx}).Decode(&v, "json")
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "error decompressing gzip body")
+
+	ctx.Request.Header.Set(fasthttp.HeaderContentEncoding, "zstd")
+	err = (&Request{RequestCtx: ctx}).Decode(&v, "json")
+	require.EqualError(t, err, "error decoding request: unsupported content encoding `zstd`")
+
+	// Bodies that exceed the max body size once decompressed.
+	g := NewGlue()
+	g.Server = &fasthttp.Server{MaxRequestBodySize: 4096}
+	bomb := append([]byte(`{"name": "`), bytes.Repeat([]byte("a"), 1<<20)...)
+	for enc, b := range map[string][]byte{
+		"gzip":    fasthttp.AppendGzipBytes(nil, bomb),
+		"deflate": fasthttp.AppendDeflateBytes(nil, bomb),
+		"br":      fasthttp.AppendBrotliBytes(nil, bomb),
+	} {
+		require.Less(t, len(b), 4096, enc)
+
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.Header.SetContentType("application/json")
+		ctx.Request.Header.Set(fasthttp.HeaderContentEncoding, enc)
+		ctx.Request.SetBody(b)
+		r := g.newRequest(ctx)
+
+		_, err := r.Body()
+		require.True(t, errors.Is(err, ErrBodyTooLarge), enc)
+		require.Error(t, r.Decode(&v, "json"), enc)
+	}
+}
+
+func TestDetach(t *testing.T) {
+	var (
+		g    = NewGlue()
+		done = make(chan *Request, 1)
+	)
+	g.POST("/items/{id}", func(r *Request) error {
+		done <- r.Detach()
+		return r.SendString(fasthttp.StatusOK, "ok")
+	})
+
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Request.Header.SetMethod(fasthttp.MethodPost)
+	ctx.Request.SetRequestURI("/items/1?dry_run=true")
+	ctx.Request.Header.Set("X-Test", "yes")
+	ctx.Request.SetBodyString(`{"name": "pen"}`)
+	g.Handler()(ctx)
+
+	// Recycle the original request like fasthttp does once the handler returns.
+	r := <-done
+	ctx.Request.SetRequestURI("/recycled")
+	ctx.Request.Header.Set("X-Test", "no")
+	ctx.Request.SetBodyString("recycled")
+	ctx.ResetUserValues()
+
+	require.Equal(t, "/items/1", string(r.RequestCtx.Path()))
+	require.Equal(t, "1", r.Param("id"))
+	require.Equal(t, "true", string(r.RequestCtx.QueryArgs().Peek("dry_run")))
+	require.Equal(t, "yes", string(r.RequestCtx.Request.Header.Peek("X-Test")))
+	require.Equal(t, `{"name": "pen"}`, string(r.RequestCtx.PostBody()))
+
+	// Writing to the detached response doesn't touch the original response.
+	require.NoError(t, r.SendString(fasthttp.StatusTeapot, "detached"))
+	require.Equal(t, "ok", string(ctx.Response.Body()))
+}
+
+func TestRegisterDecoder(t *testing.T) {
+	g := NewGlue()
+	g.RegisterDecoder("text/csv", func(b []byte, v interface{}) error {
+		rows, err := csv.NewReader(bytes.NewReader(b)).ReadAll()
+		if err != nil {
+			return err
+		}
+		*(v.(*[][]string)) = rows
+		return nil
+	})
+
+	var rows [][]string
+	g.POST("/import", func(r *Request) error {
+		rows = nil
+		if err := r.DecodeStrict(&rows, "json"); err != nil {
+			return r.SendErrorEnvelope(fasthttp.StatusBadRequest, err.Error(), nil, excepBadRequest)
+		}
+		return r.SendEnvelope(len(rows))
+	})
+
+	req := func(ct, body string) *fasthttp.RequestCtx {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.Header.SetMethod(fasthttp.MethodPost)
+		ctx.Request.SetRequestURI("/import")
+		ctx.Request.Header.SetContentType(ct)
+		ctx.Request.SetBodyString(body)
+		g.Handler()(ctx)
+		return ctx
+	}
+
+	ctx := req("text/CSV; charset=utf-8", "name,qty\npen,2\n")
+	require.Equal(t, fasthttp.StatusOK, ctx.Response.StatusCode())
+	require.Equal(t, [][]string{{"name", "qty"}, {"pen", "2"}}, rows)
+
+	// Decoder errors.
+	ctx = req("text/csv", "a,b\n\"c")
+	require.Equal(t, fasthttp.StatusBadRequest, ctx.Response.StatusCode())
+	require.Contains(t, string(ctx.Response.Body()), "error decoding request")
+
+	// JSON is still decoded as is.
+	ctx = req("application/json", `[["a"]]`)
+	require.Equal(t, fasthttp.StatusOK, ctx.Response.StatusCode())
+	require.Equal(t, [][]string{{"a"}}, rows)
+}
+
+func TestRegisterEncoder(t *testing.T) {
+	g := NewGlue()
+	g.RegisterEncoder("text/csv", func(v interface{}) ([]byte, error) {
+		var b bytes.Buffer
+		w := csv.NewWriter(&b)
+		if err := w.WriteAll(v.([][]string)); err != nil {
+			return nil, err
+		}
+		return b.Bytes(), nil
+	})
+
+	send := func(ct string, v interface{}) (*fasthttp.RequestCtx, error) {
+		ctx := &fasthttp.RequestCtx{}
+		return ctx, g.newRequest(ctx).Send(fasthttp.StatusOK, ct, v)
+	}
+
+	ctx, err := send("text/csv; charset=utf-8", [][]string{{"name", "qty"}, {"pen", "2"}})
+	require.NoError(t, err)
+	require.Equal(t, "text/csv; charset=utf-8", string(ctx.Response.Header.ContentType()))
+	require.Equal(t, "name,qty\npen,2\n", string(ctx.Response.Body()))
+
+	// Built-in encoders.
+	ctx, err = send(JSON, Person{Name: "tester"})
+	require.NoError(t, err)
+	require.JSONEq(t, `{"name":"tester","age":0,"comment":"","version":""}`, string(ctx.Response.Body()))
+
+	ctx, err = send(XML, struct {
+		XMLName struct{} `xml:"person"`
+		Name    string   `xml:"name"`
+	}{Name: "tester"})
+	require.NoError(t, err)
+	require.Equal(t, "<person><name>tester</name></person>", string(ctx.Response.Body()))
+
+	_, err = send("text/yaml", nil)
+	require.EqualError(t, err, "no encoder for content type `text/yaml`")
+}
+
+func TestRouteOptions(t *testing.T) {
+	g := NewGlue()
+	g.ConfigureServer(func(s *fasthttp.Server) {
+		s.MaxRequestBodySize = 1024
+		s.WriteTimeout = time.Second
+	})
+
+	var deadline time.Duration
+	g.POST("/upload", func(r *Request) error {
+		d, _ := r.Deadline()
+		deadline = d.Sub(r.RequestCtx.Time())
+		return r.SendEnvelope(len(r.RequestCtx.PostBody()))
+	}, RouteOptions{MaxBodySize: 1 << 20, Timeout: time.Minute})
+	g.POST("/small", func(r *Request) error {
+		return r.SendEnvelope(len(r.RequestCtx.PostBody()))
+	})
+
+	addr, stop, err := g.ListenOnRandomPort()
+	require.NoError(t, err)
+	defer stop()
+
+	// A single connection so that the upload's limit isn't carried over
+	// to the following request on the same connection.
+	c := &fasthttp.HostClient{Addr: addr, MaxConns: 1}
+	post := func(path string) int {
+		req := fasthttp.AcquireRequest()
+		resp := fasthttp.AcquireResponse()
+		defer fasthttp.ReleaseRequest(req)
+		defer fasthttp.ReleaseResponse(resp)
+
+		req.Header.SetMethod(fasthttp.MethodPost)
+		req.SetRequestURI("http://" + addr + path)
+		req.SetBody(bytes.Repeat([]byte("a"), 10*1024))
+		require.NoError(t, c.Do(req, resp))
+		return resp.StatusCode()
+	}
+
+	require.Equal(t, fasthttp.StatusOK, post("/upload"))
+	require.Equal(t, time.Minute, deadline)
+
+	// fasthttp rejects bodies over the server's limit with a 400.
+	require.Equal(t, fasthttp.StatusBadRequest, post("/small"))
+	require.Equal(t, fasthttp.StatusOK, post("/upload?x=1"))
+}
+
+func TestRouteOptionsLookup(t *testing.T) {
+	g := NewGlue()
+	g.POST("/upload/{id}", myPOSThandler, RouteOptions{MaxBodySize: 1 << 20})
+	g.Handle([]string{fasthttp.MethodPut}, []string{"/files/{path:*}"}, myPOSThandler, RouteOptions{Timeout: time.Minute})
+	g.POST("/small", myPOSThandler)
+
+	h := g.Host("{tenant}.example.com")
+	h.POST("/upload/{id}", myPOSThandler, RouteOptions{MaxBodySize: 2 << 20})
+	h.POST("/small", myPOSThandler)
+
+	lookup := func(method, host, uri string) (RouteOptions, bool) {
+		var hdr fasthttp.RequestHeader
+		hdr.SetMethod(method)
+		hdr.SetHost(host)
+		hdr.SetRequestURI(uri)
+		return g.lookupRouteOptions(&hdr)
+	}
+
+	o, ok := lookup(fasthttp.MethodPost, "api.com", "/upload/1?x=1")
+	require.True(t, ok)
+	require.Equal(t, 1<<20, o.MaxBodySize)
+
+	o, ok = lookup(fasthttp.MethodPut, "api.com", "/files/a/b.txt")
+	require.True(t, ok)
+	require.Equal(t, time.Minute, o.Timeout)
+
+	_, ok = lookup(fasthttp.MethodPost, "api.com", "/small")
+	require.False(t, ok)
+	_, ok = lookup(fasthttp.MethodGet, "api.com", "/upload/1")
+	require.False(t, ok)
+
+	// Host routes have their own options and fall back to the global routes.
+	o, ok = lookup(fasthttp.MethodPost, "acme.example.com:8080", "/upload/1")
+	require.True(t, ok)
+	require.Equal(t, 2<<20, o.MaxBodySize)
+	_, ok = lookup(fasthttp.MethodPost, "acme.example.com", "/small")
+	require.False(t, ok)
+	o, ok = lookup(fasthttp.MethodPut, "acme.example.com", "/files/a.txt")
+	require.True(t, ok)
+	require.Equal(t, time.Minute, o.Timeout)
+
+	// The hook is set once on a server.
+	var calls int
+	s := &fasthttp.Server{HeaderReceived: func(*fasthttp.RequestHeader) fasthttp.RequestConfig {
+		calls++
+		return fasthttp.RequestConfig{}
+	}}
+	g.initServer(s)
+	g.initServer(s)
+	s.HeaderReceived(&fasthttp.RequestHeader{})
+	require.Equal(t, 1, calls)
+
+	// Lookups don't allocate a RequestCtx.
+	var hdr fasthttp.RequestHeader
+	hdr.SetMethod(fasthttp.MethodPost)
+	hdr.SetRequestURI("/upload/1")
+	allocs := testing.AllocsPerRun(100, func() {
+		_, _ = g.lookupRouteOptions(&hdr)
+	})
+	require.LessOrEqual(t, allocs, float64(4))
+}
+
+func TestSendEarlyHints(t *testing.T) {
+	g := NewGlue()
+	g.GET("/page", func(r *Request) error {
+		if err := r.SendEarlyHints([]string{"</style.css>; rel=preload; as=style"}); err != nil {
+			return err
+		}
+		r.RequestCtx.Response.Header.Add("Link", "</style.css>; rel=preload; as=style")
+		return r.SendString(fasthttp.StatusOK, "page")
+	})
+
+	addr, stop, err := g.ListenOnRandomPort()
+	require.NoError(t, err)
+	defer stop()
+
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("GET /page HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+
+	// The interim response precedes the final response.
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	require.NoError(t, err)
+	require.Equal(t, fasthttp.StatusEarlyHints, resp.StatusCode)
+	require.Equal(t, "</style.css>; rel=preload; as=style", resp.Header.Get("Link"))
+
+	resp, err = http.ReadResponse(br, nil)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, fasthttp.StatusOK, resp.StatusCode)
+	body, _ := ioutil.ReadAll(resp.Body)
+	require.Equal(t, "page", string(body))
+
+	// Requests that aren't bound to a connection.
+	r := &Request{RequestCtx: &fasthttp.RequestCtx{}}
+	require.Equal(t, ErrEarlyHintsUnsupported, r.SendEarlyHints(nil))
+	require.Equal(t, ErrEarlyHintsUnsupported, r.Detach().SendEarlyHints(nil))
+}
+
+func handleHandlerName(r *Request) error {
+	return r.SendString(fasthttp.StatusOK, r.HandlerName())
+}
+
+func TestHandlerName(t *testing.T) {
+	g := NewGlue()
+	g.GET("/named", handleHandlerName)
+	g.GET("/anon", func(r *Request) error {
+		return r.SendString(fasthttp.StatusOK, r.HandlerName())
+	})
+
+	get := func(uri string) string {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.SetRequestURI(uri)
+		g.Handler()(ctx)
+		return string(ctx.Response.Body())
+	}
+
+	require.Equal(t, "github.com/zerodha/fastglue.handleHandlerName", get("/named"))
+	require.Equal(t, "github.com/zerodha/fastglue.TestHandlerName.func1", get("/anon"))
+}
+
+func TestTransform(t *testing.T) {
+	g := NewGlue()
+	g.Transform(func(r *Request, body []byte) []byte {
+		if !bytes.HasPrefix(r.RequestCtx.Response.Header.ContentType(), []byte("text/html")) {
+			return body
+		}
+		return append(body, "<!-- marker -->"...)
+	})
+
+	var size int
+	g.OnResponse(func(r *Request, status int, bytes int) {
+		size = bytes
+	})
+	g.GET("/page", func(r *Request) error {
+		return r.SendBytes(fasthttp.StatusOK, "text/html; charset=utf-8", []byte("<p>page</p>"))
+	})
+	g.GET("/json", func(r *Request) error {
+		return r.SendEnvelope("ok")
+	})
+
+	get := func(uri string) string {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.SetRequestURI(uri)
+		g.Handler()(ctx)
+		return string(ctx.Response.Body())
+	}
+
+	require.Equal(t, "<p>page</p><!-- marker -->", get("/page"))
+	require.Equal(t, len("<p>page</p><!-- marker -->"), size)
+	require.Equal(t, `{"status":"success","data":"ok"}`, get("/json"))
+}
+
+func TestSendStream(t *testing.T) {
+	content := []byte("0123456789abcdefghij")
+
+	send := func(rng string) *fasthttp.RequestCtx {
+		ctx := &fasthttp.RequestCtx{}
+		if rng != "" {
+			ctx.Request.Header.Set(fasthttp.HeaderRange, rng)
+		}
+		r := &Request{RequestCtx: ctx}
+		require.NoError(t, r.SendStream("video/mp4", bytes.NewReader(content)))
+		return ctx
+	}
+
+	ctx := send("")
+	require.Equal(t, fasthttp.StatusOK, ctx.Response.StatusCode())
+	require.Equal(t, "bytes", string(ctx.Response.Header.Peek(fasthttp.HeaderAcceptRanges)))
+	require.Equal(t, content, ctx.Response.Body())
+
+	for _, c := range []struct {
+		rng, body, contentRange string
+	}{
+		{"bytes=2-5", "2345", "bytes 2-5/20"},
+		{"bytes=15-", "fghij", "bytes 15-19/20"},
+		{"bytes=-3", "hij", "bytes 17-19/20"},
+		{"bytes=18-100", "ij", "bytes 18-19/20"},
+	} {
+		ctx = send(c.rng)
+		require.Equal(t, fasthttp.StatusPartialContent, ctx.Response.StatusCode(), c.rng)
+		require.Equal(t, c.contentRange, string(ctx.Response.Header.Peek(fasthttp.HeaderContentRange)), c.rng)
+		require.Equal(t, c.body, string(ctx.Response.Body()), c.rng)
+	}
+
+	ctx = send("bytes=30-40")
+	require.Equal(t, fasthttp.StatusRequestedRangeNotSatisfiable, ctx.Response.StatusCode())
+	require.Equal(t, "bytes */20", string(ctx.Response.Header.Peek(fasthttp.HeaderContentRange)))
+
+	// Multiple ranges get the whole content.
+	ctx = send("bytes=0-1,5-6")
+	require.Equal(t, fasthttp.StatusOK, ctx.Response.StatusCode())
+	require.Equal(t, content, ctx.Response.Body())
+
+	// Closers are closed when the content isn't streamed.
+	for _, c := range []struct {
+		rng     string
+		seekErr bool
+	}{
+		{"bytes=30-40", false},
+		{"", true},
+	} {
+		rc := &seekCloser{ReadSeeker: bytes.NewReader(content), seekErr: c.seekErr}
+		ctx := &fasthttp.RequestCtx{}
+		if c.rng != "" {
+			ctx.Request.Header.Set(fasthttp.HeaderRange, c.rng)
+		}
+		err := (&Request{RequestCtx: ctx}).SendStream("video/mp4", rc)
+		require.Equal(t, c.seekErr, err != nil)
+		require.True(t, rc.closed, c.rng)
+	}
+}
+
+type seekCloser struct {
+	io.ReadSeeker
+	seekErr bool
+	closed  bool
+}
+
+func (s *seekCloser) Seek(offset int64, whence int) (int64, error) {
+	if s.seekErr {
+		return 0, errors.New("seek error")
+	}
+	return s.ReadSeeker.Seek(offset, whence)
+}
+
+func (s *seekCloser) Close() error {
+	s.closed = true
+	return nil
+}
+
+func TestSendNDJSON(t *testing.T) {
+	stopped := make(chan struct{})
+
+	g := NewGlue()
+	g.GET("/records", func(r *Request) error {
+		ch := make(chan interface{})
+		go func() {
+			defer close(ch)
+			for i := 1; i <= 3; i++ {
+				ch <- map[string]int{"id": i}
+			}
+		}()
+		return r.SendNDJSON(fasthttp.StatusOK, ch)
+	})
+	g.GET("/tail", func(r *Request) error {
+		var (
+			ch  = make(chan interface{})
+			ctx = r.StdContext()
+		)
+		go func() {
+			defer close(stopped)
+			for i := 1; ; i++ {
+				select {
+				case ch <- map[string]int{"id": i}:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+		return r.SendNDJSON(fasthttp.StatusOK, ch)
+	})
+
+	addr, stop, err := g.ListenOnRandomPort()
+	require.NoError(t, err)
+	defer stop()
+
+	resp, err := http.Get("http://" + addr + "/records")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, NDJSON, resp.Header.Get("Content-Type"))
+
+	var (
+		sc   = bufio.NewScanner(resp.Body)
+		recs []string
+	)
+	for sc.Scan() {
+		recs = append(recs, sc.Text())
+	}
+	require.Equal(t, []string{`{"id":1}`, `{"id":2}`, `{"id":3}`}, recs)
+
+	// The stream stops once the client goes away.
+	resp, err = http.Get("http://" + addr + "/tail")
+	require.NoError(t, err)
+	sc = bufio.NewScanner(resp.Body)
+	require.True(t, sc.Scan())
+	require.Equal(t, `{"id":1}`, sc.Text())
+	resp.Body.Close()
+
+	select {
+	case <-stopped:
+	case <-time.After(time.Second * 5):
+		t.Fatal("the producer wasn't stopped after the client went away")
+	}
+}
+
+func TestBindParams(t *testing.T) {
+	type query struct {
+		Pagination
+		Sort string `url:"sort,oneof=asc desc"`
+	}
+
+	g := NewGlue()
+	g.GET("/items", BindParams(func(r *Request) error {
+		return r.SendEnvelope(r.BoundParams().(*query))
+	}, query{}, "url"))
+
+	get := func(uri string) (*fasthttp.RequestCtx, Envelope) {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.SetRequestURI(uri)
+		g.Handler()(ctx)
+
+		var e Envelope
+		require.NoError(t, json.Unmarshal(ctx.Response.Body(), &e))
+		return ctx, e
+	}
+
+	ctx, e := get("/items?page=2&per_page=10&sort=asc")
+	require.Equal(t, fasthttp.StatusOK, ctx.Response.StatusCode())
+	require.Equal(t, map[string]interface{}{"Page": 2.0, "PerPage": 10.0, "Sort": "asc"}, e.Data)
+
+	// Bad params get the errors of every field.
+	ctx, e = get("/items?page=x&sort=random")
+	require.Equal(t, fasthttp.StatusBadRequest, ctx.Response.StatusCode())
+	require.Equal(t, "Invalid request params", *e.Message)
+	require.Equal(t, map[string]interface{}{
+		"page": "failed to decode `page`, got: `x` (expected int)",
+		"sort": "failed to decode `sort`, got: `random` (expected one of: asc, desc)",
+	}, e.Data)
+
+	require.Panics(t, func() { BindParams(nil, "", "url") })
+}
+
+func TestSetUseNumber(t *testing.T) {
+	g := NewGlue()
+
+	decode := func(body string, v interface{}) error {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.Header.SetContentType(JSON)
+		ctx.Request.SetBodyString(body)
+		return g.newRequest(ctx).Decode(v, "json")
+	}
+
+	// Large integers lose precision as float64s by default.
+	var m map[string]interface{}
+	require.NoError(t, decode(`{"id": 9007199254740993}`, &m))
+	require.Equal(t, float64(9007199254740992), m["id"])
+
+	g.SetUseNumber(true)
+	m = nil
+	require.NoError(t, decode(`{"id": 9007199254740993, "items": [{"qty": 1.5}]}`, &m))
+	require.Equal(t, json.Number("9007199254740993"), m["id"])
+	n, err := m["id"].(json.Number).Int64()
+	require.NoError(t, err)
+	require.Equal(t, int64(9007199254740993), n)
+	require.Equal(t, json.Number("1.5"), m["items"].([]interface{})[0].(map[string]interface{})["qty"])
+
+	// Typed fields are unaffected.
+	var p struct {
+		ID   int64       `json:"id"`
+		Meta interface{} `json:"meta"`
+	}
+	require.NoError(t, decode(`{"id": 9007199254740993, "meta": 10}`, &p))
+	require.Equal(t, int64(9007199254740993), p.ID)
+	require.Equal(t, json.Number("10"), p.Meta)
+
+	// Errors are the same as without the option.
+	err = decode(`{"id": 1} x`, &m)
+	var de *DecodeError
+	require.True(t, errors.As(err, &de))
+	require.Equal(t, int64(11), de.Offset)
+}
@@ -0,0 +1,156 @@
+package fastglue
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// fetchClient is the default client used by Request.FetchJSON.
+var fetchClient = &fasthttp.Client{}
+
+// FetchOptions represents the options for Request.FetchJSON.
+type FetchOptions struct {
+	// Retries is the number of times a failed request is retried. Requests are
+	// retried on network errors, 5xx and 429 responses.
+	Retries int
+
+	// Backoff is the wait before the first retry, which is doubled on every
+	// subsequent retry up to MaxBackoff. Defaults to 100ms.
+	Backoff time.Duration
+
+	// MaxBackoff is the maximum wait between retries. Defaults to 5 seconds.
+	MaxBackoff time.Duration
+
+	// Timeout is the timeout of every attempt. Defaults to 10 seconds.
+	Timeout time.Duration
+
+	// Headers are optional headers to set on the request.
+	Headers map[string]string
+
+	// Client is an optional fasthttp.Client to use for the requests.
+	Client *fasthttp.Client
+
+	// Context, when it's done, stops further retries. Attempts are also cut short
+	// at its deadline. Defaults to the request's StdContext(), which is cancelled
+	// when the client goes away.
+	Context context.Context
+}
+
+// FetchError is returned by Request.FetchJSON when the upstream
+// responds with a non-2xx status.
+type FetchError struct {
+	StatusCode int
+	Body       []byte
+}
+
+func (e *FetchError) Error() string {
+	return fmt.Sprintf("upstream responded with %d", e.StatusCode)
+}
+
+// FetchJSON makes an outbound HTTP request to the given URL with the JSON
+// encoded body (if it's not nil) and decodes the JSON response into out (if it's
+// not nil). Failed requests are retried with exponential backoff as per the options.
+// Non-2xx responses are returned as *FetchError. If the context is done, no more
+// attempts are made and the context's error is returned.
+func (r *Request) FetchJSON(method, url string, body interface{}, out interface{}, o FetchOptions) error {
+	if o.Backoff == 0 {
+		o.Backoff = time.Millisecond * 100
+	}
+	if o.MaxBackoff == 0 {
+		o.MaxBackoff = time.Second * 5
+	}
+	if o.Timeout == 0 {
+		o.Timeout = time.Second * 10
+	}
+	if o.Client == nil {
+		o.Client = fetchClient
+	}
+	if o.Context == nil {
+		o.Context = r.StdContext()
+	}
+
+	var b []byte
+	if body != nil {
+		var err error
+		if b, err = json.Marshal(body); err != nil {
+			return fmt.Errorf("error marshalling request body: %v", err)
+		}
+	}
+
+	req := fasthttp.AcquireRequest()
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseRequest(req)
+	defer fasthttp.ReleaseResponse(resp)
+
+	req.Header.SetMethod(method)
+	req.SetRequestURI(url)
+	req.Header.Set("Accept", JSON)
+	if b != nil {
+		req.Header.SetContentType(JSON)
+		req.SetBody(b)
+	}
+	for k, v := range o.Headers {
+		req.Header.Set(k, v)
+	}
+
+	var (
+		wait = o.Backoff
+		err  error
+	)
+	for attempt := 0; ; attempt++ {
+		if errCtx := o.Context.Err(); errCtx != nil {
+			return fmt.Errorf("request aborted: %w", errCtx)
+		}
+
+		if err = o.Client.DoDeadline(req, resp, ctxDeadline(o.Context, o.Timeout)); err == nil {
+			code := resp.StatusCode()
+			if code >= 200 && code <= 299 {
+				break
+			}
+
+			err = &FetchError{StatusCode: code, Body: append([]byte(nil), resp.Body()...)}
+			if code < 500 && code != fasthttp.StatusTooManyRequests {
+				return err
+			}
+		}
+
+		if attempt >= o.Retries {
+			return err
+		}
+
+		t := time.NewTimer(wait)
+		select {
+		case <-o.Context.Done():
+			t.Stop()
+			return fmt.Errorf("request aborted: %w", o.Context.Err())
+		case <-t.C:
+		}
+
+		if wait *= 2; wait > o.MaxBackoff {
+			wait = o.MaxBackoff
+		}
+	}
+
+	if out == nil || len(resp.Body()) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(resp.Body(), out); err != nil {
+		return fmt.Errorf("error unmarshalling response: %v", err)
+	}
+
+	return nil
+}
+
+// ctxDeadline returns the deadline for an outbound request with the given
+// timeout, which is cut short by the context's deadline, if there's one.
+func ctxDeadline(ctx context.Context, timeout time.Duration) time.Time {
+	d := time.Now().Add(timeout)
+	if cd, ok := ctx.Deadline(); ok && cd.Before(d) {
+		return cd
+	}
+	return d
+}
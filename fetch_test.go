@@ -0,0 +1,89 @@
+package fastglue
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/valyala/fasthttp"
+)
+
+func TestFetchJSON(t *testing.T) {
+	m := NewMockServer()
+	defer m.Server.Close()
+
+	var calls int
+	m.HandleFunc(fasthttp.MethodPost, "/flaky", func(r *http.Request) MockResponse {
+		calls++
+		if calls <= 2 {
+			return MockResponse{StatusCode: fasthttp.StatusServiceUnavailable}
+		}
+		return MockResponse{ContentType: JSON, Body: []byte(`{"id": 1}`)}
+	})
+	m.Handle(fasthttp.MethodGet, "/bad", MockResponse{StatusCode: fasthttp.StatusBadRequest})
+
+	var (
+		req = &Request{RequestCtx: &fasthttp.RequestCtx{}}
+		o   = FetchOptions{Retries: 2, Backoff: time.Millisecond}
+		out struct {
+			ID int `json:"id"`
+		}
+	)
+
+	// The request fails twice and the retry recovers.
+	require.NoError(t, req.FetchJSON(fasthttp.MethodPost, m.URL()+"/flaky", map[string]string{"a": "b"}, &out, o))
+	require.Equal(t, 3, calls)
+	require.Equal(t, 1, out.ID)
+
+	// Out of retries.
+	calls = 0
+	o.Retries = 1
+	err := req.FetchJSON(fasthttp.MethodPost, m.URL()+"/flaky", nil, &out, o)
+	var fe *FetchError
+	require.True(t, errors.As(err, &fe))
+	require.Equal(t, fasthttp.StatusServiceUnavailable, fe.StatusCode)
+	require.Equal(t, 2, calls)
+
+	// 4xx responses aren't retried.
+	err = req.FetchJSON(fasthttp.MethodGet, m.URL()+"/bad", nil, nil, o)
+	require.True(t, errors.As(err, &fe))
+	require.Equal(t, fasthttp.StatusBadRequest, fe.StatusCode)
+}
+
+func TestFetchJSONCancel(t *testing.T) {
+	m := NewMockServer()
+	defer m.Server.Close()
+
+	var calls int32
+	m.HandleFunc(fasthttp.MethodGet, "/down", func(r *http.Request) MockResponse {
+		atomic.AddInt32(&calls, 1)
+		return MockResponse{StatusCode: fasthttp.StatusServiceUnavailable}
+	})
+
+	// Cancel the context while the helper is waiting to retry.
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(time.Millisecond*50, cancel)
+
+	var (
+		req   = &Request{RequestCtx: &fasthttp.RequestCtx{}}
+		o     = FetchOptions{Retries: 5, Backoff: time.Second, Context: ctx}
+		start = time.Now()
+	)
+	err := req.FetchJSON(fasthttp.MethodGet, m.URL()+"/down", nil, nil, o)
+	require.True(t, errors.Is(err, context.Canceled))
+	require.Equal(t, int32(1), atomic.LoadInt32(&calls))
+	require.Less(t, int64(time.Since(start)), int64(time.Second))
+
+	// The request's context is picked up by default.
+	sctx := req.StdContext()
+	req.RequestCtx.UserValue(keyStdContext).(*stdContext).Close()
+	<-sctx.Done()
+	o.Context = nil
+	err = req.FetchJSON(fasthttp.MethodGet, m.URL()+"/down", nil, nil, o)
+	require.True(t, errors.Is(err, context.Canceled))
+	require.Equal(t, int32(1), atomic.LoadInt32(&calls))
+}
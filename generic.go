@@ -0,0 +1,17 @@
+package fastglue
+
+// DecodeFailT is the same as Request.DecodeFail but decodes into a new value
+// of type T and returns it. The zero value of T is returned on failure. eg:
+//
+//	p, err := fastglue.DecodeFailT[Person](r, "json")
+//	if err != nil {
+//		return err
+//	}
+func DecodeFailT[T any](r *Request, tag string) (T, error) {
+	var v T
+	if err := r.DecodeFail(&v, tag); err != nil {
+		var zero T
+		return zero, err
+	}
+	return v, nil
+}
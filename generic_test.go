@@ -0,0 +1,31 @@
+package fastglue
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/valyala/fasthttp"
+)
+
+func TestDecodeFailT(t *testing.T) {
+	req := func(body string) (*fasthttp.RequestCtx, Person, error) {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.Header.SetMethod(fasthttp.MethodPost)
+		ctx.Request.Header.SetContentType(JSON)
+		ctx.Request.SetBodyString(body)
+
+		p, err := DecodeFailT[Person](&Request{RequestCtx: ctx}, "json")
+		return ctx, p, err
+	}
+
+	ctx, p, err := req(`{"name": "tester", "age": 30}`)
+	require.NoError(t, err)
+	require.Equal(t, Person{Name: "tester", Age: 30}, p)
+	require.Empty(t, ctx.Response.Body())
+
+	ctx, p, err = req(`{"name": "tester", "age": "thirty"}`)
+	require.Error(t, err)
+	require.Equal(t, Person{}, p)
+	require.Equal(t, fasthttp.StatusBadRequest, ctx.Response.StatusCode())
+	require.Contains(t, string(ctx.Response.Body()), `"error_type":"InputException"`)
+}
@@ -0,0 +1,214 @@
+package fastglue
+
+import (
+	"bytes"
+	"strings"
+
+	fasthttprouter "github.com/fasthttp/router"
+	"github.com/valyala/fasthttp"
+)
+
+// HostRouter registers routes that only match requests to the hosts that match
+// its pattern. It's created with Fastglue.Host.
+type HostRouter struct {
+	glue    *Fastglue
+	pattern string
+	labels  []string
+	params  []string
+	router  *fasthttprouter.Router
+	options *routeOptionsSet
+}
+
+// Host returns the HostRouter for the given host pattern (eg: {tenant}.api.example.com)
+// on which routes that only match requests to those hosts can be registered.
+// Labels of the pattern in braces are wildcards that match any single label of the
+// host, which is available in the handlers as a param, eg: r.Param("tenant").
+// Hosts are matched case-insensitively and without the port in the order in which
+// their patterns are registered. Requests to a matching host that don't match any of
+// its routes are routed with the global routes. HostRouters have the same settings
+// (eg: SetRedirectTrailingSlash) as the global router.
+func (f *Fastglue) Host(pattern string) *HostRouter {
+	pattern = strings.ToLower(pattern)
+	for _, h := range f.hosts {
+		if h.pattern == pattern {
+			return h
+		}
+	}
+
+	h := &HostRouter{
+		glue:    f,
+		pattern: pattern,
+		labels:  strings.Split(pattern, "."),
+		router:  fasthttprouter.New(),
+	}
+	for _, l := range h.labels {
+		if isHostWildcard(l) {
+			h.params = append(h.params, l[1:len(l)-1])
+		}
+	}
+	h.configure()
+
+	// Fall back to the global routes.
+	fallback := func(ctx *fasthttp.RequestCtx) {
+		f.Router.Handler(ctx)
+	}
+	h.router.NotFound = fallback
+	h.router.MethodNotAllowed = fallback
+
+	f.hosts = append(f.hosts, h)
+	return h
+}
+
+// POST is fastglue's wrapper over fasthttprouter's handler.
+func (h *HostRouter) POST(path string, fn FastRequestHandler, o ...RouteOptions) {
+	h.handle(fasthttp.MethodPost, path, h.glue.handler(fn, o...), o)
+}
+
+// GET is fastglue's wrapper over fasthttprouter's handler.
+func (h *HostRouter) GET(path string, fn FastRequestHandler, o ...RouteOptions) {
+	h.handle(fasthttp.MethodGet, path, h.glue.handler(fn, o...), o)
+}
+
+// PUT is fastglue's wrapper over fasthttprouter's handler.
+func (h *HostRouter) PUT(path string, fn FastRequestHandler, o ...RouteOptions) {
+	h.handle(fasthttp.MethodPut, path, h.glue.handler(fn, o...), o)
+}
+
+// DELETE is fastglue's wrapper over fasthttprouter's handler.
+func (h *HostRouter) DELETE(path string, fn FastRequestHandler, o ...RouteOptions) {
+	h.handle(fasthttp.MethodDelete, path, h.glue.handler(fn, o...), o)
+}
+
+// OPTIONS is fastglue's wrapper over fasthttprouter's handler.
+func (h *HostRouter) OPTIONS(path string, fn FastRequestHandler, o ...RouteOptions) {
+	h.handle(fasthttp.MethodOptions, path, h.glue.handler(fn, o...), o)
+}
+
+// HEAD is fastglue's wrapper over fasthttprouter's handler.
+func (h *HostRouter) HEAD(path string, fn FastRequestHandler, o ...RouteOptions) {
+	h.handle(fasthttp.MethodHead, path, h.glue.handler(fn, o...), o)
+}
+
+// Any is the same as Fastglue.Any for the host's routes.
+func (h *HostRouter) Any(path string, fn FastRequestHandler, o ...RouteOptions) {
+	methods := h.glue.anyMethods
+	if len(methods) == 0 {
+		methods = defaultAnyMethods
+	}
+
+	fh := h.glue.handler(fn, o...)
+	for _, m := range methods {
+		h.handle(m, path, fh, o)
+	}
+}
+
+// Handle is the same as Fastglue.Handle for the host's routes.
+func (h *HostRouter) Handle(methods []string, paths []string, fn FastRequestHandler, o ...RouteOptions) {
+	fh := h.glue.handler(fn, o...)
+	for _, p := range paths {
+		for _, m := range methods {
+			h.handle(m, p, fh, o)
+		}
+	}
+}
+
+// configure applies the settings of the global router to the host's router.
+func (h *HostRouter) configure() {
+	r := h.glue.Router
+	h.router.SaveMatchedRoutePath = r.SaveMatchedRoutePath
+	h.router.RedirectTrailingSlash = r.RedirectTrailingSlash
+	h.router.RedirectFixedPath = r.RedirectFixedPath
+	h.router.HandleMethodNotAllowed = r.HandleMethodNotAllowed
+	h.router.HandleOPTIONS = r.HandleOPTIONS
+	h.router.GlobalOPTIONS = r.GlobalOPTIONS
+	h.router.PanicHandler = r.PanicHandler
+}
+
+// configureHosts applies the settings of the global router to the HostRouters.
+func (f *Fastglue) configureHosts() {
+	for _, h := range f.hosts {
+		h.configure()
+	}
+}
+
+// handle registers the fasthttp handler and the options (if any) of a route.
+func (h *HostRouter) handle(method, path string, fh fasthttp.RequestHandler, o []RouteOptions) {
+	h.router.Handle(method, path, h.glue.withPath(path, fh))
+	if len(o) == 0 {
+		return
+	}
+	if h.options == nil {
+		h.options = newRouteOptionsSet()
+	}
+	h.options.set(method, path, o[0])
+}
+
+// matches checks whether the host (without the port) matches the pattern.
+func (h *HostRouter) matches(host []byte) bool {
+	if bytes.Count(host, []byte(".")) != len(h.labels)-1 {
+		return false
+	}
+
+	for _, l := range h.labels {
+		var label []byte
+		if i := bytes.IndexByte(host, '.'); i >= 0 {
+			label, host = host[:i], host[i+1:]
+		} else {
+			label = host
+		}
+
+		if isHostWildcard(l) {
+			if len(label) == 0 {
+				return false
+			}
+			continue
+		}
+		if !bytes.EqualFold(label, []byte(l)) {
+			return false
+		}
+	}
+	return true
+}
+
+// setParams sets the values of the wildcard labels of the (matching)
+// host as user values on the request.
+func (h *HostRouter) setParams(ctx *fasthttp.RequestCtx, host []byte) {
+	if len(h.params) == 0 {
+		return
+	}
+	ctx.SetUserValue(keyHostParamNames, &h.params)
+
+	labels := bytes.Split(host, []byte("."))
+	for i, l := range h.labels {
+		if isHostWildcard(l) {
+			ctx.SetUserValue(l[1:len(l)-1], strings.ToLower(string(labels[i])))
+		}
+	}
+}
+
+// routeHost routes the request with the routes of the first HostRouter whose
+// pattern matches the request's host and returns false if none match.
+func (f *Fastglue) routeHost(ctx *fasthttp.RequestCtx) bool {
+	host := hostname(ctx.Host())
+	for _, h := range f.hosts {
+		if h.matches(host) {
+			h.setParams(ctx, host)
+			h.router.Handler(ctx)
+			return true
+		}
+	}
+	return false
+}
+
+// hostname returns the host without the port, if it has one.
+func hostname(host []byte) []byte {
+	if i := bytes.LastIndexByte(host, ':'); i >= 0 && bytes.IndexByte(host[i:], ']') < 0 {
+		return host[:i]
+	}
+	return host
+}
+
+// isHostWildcard checks whether a label of a host pattern is a wildcard, eg: {tenant}.
+func isHostWildcard(l string) bool {
+	return len(l) > 2 && l[0] == '{' && l[len(l)-1] == '}'
+}
@@ -0,0 +1,94 @@
+package fastglue
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/valyala/fasthttp"
+)
+
+func TestHost(t *testing.T) {
+	g := NewGlue()
+	g.Host("{tenant}.api.example.com").GET("/info", func(r *Request) error {
+		return r.SendString(fasthttp.StatusOK, "tenant "+r.Param("tenant"))
+	})
+	g.Host("admin.example.com").GET("/info", func(r *Request) error {
+		return r.SendString(fasthttp.StatusOK, "admin")
+	})
+	g.GET("/info", func(r *Request) error {
+		return r.SendString(fasthttp.StatusOK, "global")
+	})
+	g.GET("/health", func(r *Request) error {
+		return r.SendString(fasthttp.StatusOK, "ok")
+	})
+
+	get := func(host, uri string) (int, string) {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.SetRequestURI(uri)
+		ctx.Request.Header.SetHost(host)
+		g.Handler()(ctx)
+		return ctx.Response.StatusCode(), string(ctx.Response.Body())
+	}
+
+	for _, c := range []struct {
+		host, uri, body string
+	}{
+		{"acme.api.example.com", "/info", "tenant acme"},
+		{"Globex.API.example.com:8080", "/info", "tenant globex"},
+		{"admin.example.com", "/info", "admin"},
+		{"example.com", "/info", "global"},
+		{"api.example.com", "/info", "global"},
+		{"a.b.api.example.com", "/info", "global"},
+
+		// Routes that the host doesn't have fall back to the global routes.
+		{"acme.api.example.com", "/health", "ok"},
+	} {
+		code, body := get(c.host, c.uri)
+		require.Equal(t, fasthttp.StatusOK, code, c.host+c.uri)
+		require.Equal(t, c.body, body, c.host+c.uri)
+	}
+
+	code, _ := get("acme.api.example.com", "/missing")
+	require.Equal(t, fasthttp.StatusNotFound, code)
+
+	// The same pattern returns the same router.
+	require.Equal(t, g.Host("{tenant}.api.example.com"), g.Host("{TENANT}.api.example.com"))
+}
+
+func TestHostRouterSettings(t *testing.T) {
+	g := NewGlue()
+	h := g.Host("{tenant}.example.com")
+	h.GET("/users/{id}", func(r *Request) error {
+		return r.SendString(fasthttp.StatusOK, r.RoutePattern())
+	})
+	h.Any("/any", func(r *Request) error {
+		return r.SendString(fasthttp.StatusOK, string(r.RequestCtx.Method()))
+	})
+	h.Handle([]string{fasthttp.MethodGet}, []string{"/ping", "/v1/ping"}, func(r *Request) error {
+		return r.SendString(fasthttp.StatusOK, "pong")
+	})
+
+	req := func(method, uri string) (int, string) {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.Header.SetMethod(method)
+		ctx.Request.SetRequestURI(uri)
+		ctx.Request.Header.SetHost("acme.example.com")
+		g.Handler()(ctx)
+		return ctx.Response.StatusCode(), string(ctx.Response.Body())
+	}
+
+	_, body := req(fasthttp.MethodGet, "/users/1")
+	require.Equal(t, "/users/{id}", body)
+	_, body = req(fasthttp.MethodPut, "/any")
+	require.Equal(t, fasthttp.MethodPut, body)
+	_, body = req(fasthttp.MethodGet, "/v1/ping")
+	require.Equal(t, "pong", body)
+
+	// Settings of the global router apply to the host's routes.
+	code, _ := req(fasthttp.MethodGet, "/users/1/")
+	require.Equal(t, fasthttp.StatusMovedPermanently, code)
+
+	g.SetRedirectTrailingSlash(false)
+	code, _ = req(fasthttp.MethodGet, "/users/1/")
+	require.Equal(t, fasthttp.StatusNotFound, code)
+}
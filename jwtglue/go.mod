@@ -0,0 +1,25 @@
+module github.com/zerodha/fastglue/jwtglue
+
+go 1.21
+
+require (
+	github.com/golang-jwt/jwt/v5 v5.2.1
+	github.com/stretchr/testify v1.6.0
+	github.com/valyala/fasthttp v1.34.0
+	github.com/zerodha/fastglue v0.0.0-00010101000000-000000000000
+)
+
+require (
+	github.com/andybalholm/brotli v1.0.4 // indirect
+	github.com/davecgh/go-spew v1.1.0 // indirect
+	github.com/fasthttp/router v1.4.5 // indirect
+	github.com/klauspost/compress v1.15.0 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+	github.com/savsgio/gotils v0.0.0-20211223103454-d0aaa54c5899 // indirect
+	github.com/valyala/bytebufferpool v1.0.0 // indirect
+	gopkg.in/yaml.v3 v3.0.0-20200313102051-9f266ea9e77c // indirect
+)
+
+// Until a fastglue release that includes this module is tagged, it's
+// built against the fastglue in this repository.
+replace github.com/zerodha/fastglue => ../
@@ -0,0 +1,81 @@
+// Package jwtglue adds JSON Web Token (JWT) authentication to fastglue.
+// It's a separate module so that users who don't use JWTs don't have to
+// pull in the JWT dependency.
+package jwtglue
+
+import (
+	"bytes"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/valyala/fasthttp"
+	"github.com/zerodha/fastglue"
+)
+
+// Options represents the options for the Auth middleware.
+type Options struct {
+	// Keyfunc returns the key for verifying the signature of a token, for instance,
+	// by looking up the `kid` header of the token in a key set.
+	Keyfunc jwt.Keyfunc
+
+	// Methods are the signing methods (eg: HS256, RS256) that tokens are accepted with.
+	// Tokens signed with other methods are rejected to prevent algorithm confusion.
+	Methods []string
+
+	// NewClaims returns a new value to parse the claims of a token into.
+	// Defaults to jwt.MapClaims.
+	NewClaims func() jwt.Claims
+
+	// Leeway is the allowed clock skew when checking the exp and nbf claims.
+	Leeway time.Duration
+
+	// UserValueKey is the RequestCtx user value key with which the claims
+	// are set on the request. Defaults to `jwt_claims`.
+	UserValueKey string
+}
+
+// Auth is an (opinionated) middleware that authenticates requests with the bearer token
+// in the Authorization header. It verifies the token's signature with the Keyfunc and
+// checks its exp and nbf claims. On success, the claims are set as a user value on the
+// request (see Claims). Otherwise, it fails the request with a 401 error envelope.
+// It should be registered with Before().
+func Auth(o Options) fastglue.FastMiddleware {
+	if o.NewClaims == nil {
+		o.NewClaims = func() jwt.Claims {
+			return jwt.MapClaims{}
+		}
+	}
+	if o.UserValueKey == "" {
+		o.UserValueKey = "jwt_claims"
+	}
+
+	opts := []jwt.ParserOption{jwt.WithLeeway(o.Leeway)}
+	if len(o.Methods) > 0 {
+		opts = append(opts, jwt.WithValidMethods(o.Methods))
+	}
+	p := jwt.NewParser(opts...)
+
+	return func(r *fastglue.Request) *fastglue.Request {
+		h := r.RequestCtx.Request.Header.Peek(fasthttp.HeaderAuthorization)
+		if len(h) < 7 || !bytes.EqualFold(h[:7], []byte("Bearer ")) {
+			_ = r.SendErrorEnvelope(fasthttp.StatusUnauthorized, "Missing token", nil, "AuthException")
+			return nil
+		}
+
+		t, err := p.ParseWithClaims(string(bytes.TrimSpace(h[7:])), o.NewClaims(), o.Keyfunc)
+		if err != nil || !t.Valid {
+			_ = r.SendErrorEnvelope(fasthttp.StatusUnauthorized, "Invalid token", nil, "AuthException")
+			return nil
+		}
+
+		r.RequestCtx.SetUserValue(o.UserValueKey, t.Claims)
+		return r
+	}
+}
+
+// Claims returns the claims set on the request by the Auth middleware
+// with the default UserValueKey, or nil if there are none.
+func Claims(r *fastglue.Request) jwt.Claims {
+	c, _ := r.RequestCtx.UserValue("jwt_claims").(jwt.Claims)
+	return c
+}
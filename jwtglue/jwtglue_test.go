@@ -0,0 +1,69 @@
+package jwtglue
+
+import (
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/require"
+	"github.com/valyala/fasthttp"
+	"github.com/zerodha/fastglue"
+)
+
+func TestAuth(t *testing.T) {
+	secret := []byte("secret")
+
+	g := fastglue.NewGlue()
+	g.Before(Auth(Options{
+		Keyfunc: func(t *jwt.Token) (interface{}, error) {
+			return secret, nil
+		},
+		Methods: []string{"HS256"},
+	}))
+	g.GET("/me", func(r *fastglue.Request) error {
+		sub, _ := Claims(r).GetSubject()
+		return r.SendString(fasthttp.StatusOK, sub)
+	})
+
+	token := func(m jwt.SigningMethod, key interface{}, c jwt.MapClaims) string {
+		s, err := jwt.NewWithClaims(m, c).SignedString(key)
+		require.NoError(t, err)
+		return s
+	}
+	get := func(auth string) *fasthttp.RequestCtx {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.SetRequestURI("/me")
+		if auth != "" {
+			ctx.Request.Header.Set(fasthttp.HeaderAuthorization, auth)
+		}
+		g.Handler()(ctx)
+		return ctx
+	}
+
+	now := time.Now()
+	ctx := get("Bearer " + token(jwt.SigningMethodHS256, secret, jwt.MapClaims{
+		"sub": "user1",
+		"exp": now.Add(time.Hour).Unix(),
+	}))
+	require.Equal(t, fasthttp.StatusOK, ctx.Response.StatusCode())
+	require.Equal(t, "user1", string(ctx.Response.Body()))
+
+	for name, auth := range map[string]string{
+		"missing": "",
+		"scheme":  "Token abc",
+		"expired": "Bearer " + token(jwt.SigningMethodHS256, secret, jwt.MapClaims{
+			"sub": "user1",
+			"exp": now.Add(-time.Hour).Unix(),
+		}),
+		"not yet valid": "Bearer " + token(jwt.SigningMethodHS256, secret, jwt.MapClaims{
+			"sub": "user1",
+			"nbf": now.Add(time.Hour).Unix(),
+		}),
+		"bad signature": "Bearer " + token(jwt.SigningMethodHS256, []byte("other"), jwt.MapClaims{"sub": "user1"}),
+		"bad method":    "Bearer " + token(jwt.SigningMethodHS512, secret, jwt.MapClaims{"sub": "user1"}),
+	} {
+		ctx := get(auth)
+		require.Equal(t, fasthttp.StatusUnauthorized, ctx.Response.StatusCode(), name)
+		require.Contains(t, string(ctx.Response.Body()), `"error_type":"AuthException"`, name)
+	}
+}
@@ -0,0 +1,71 @@
+package fastglue
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// ConcurrencyOptions represents the options for the Concurrency middleware.
+type ConcurrencyOptions struct {
+	// QueueTimeout is the maximum duration for which requests over the limit are
+	// queued, waiting for a slot, before they're rejected. If it's 0, they're
+	// rejected right away.
+	QueueTimeout time.Duration
+}
+
+// concurrencySlot is a slot of the Concurrency middleware held by a request.
+// It's set as a user value on the request and fasthttp closes user values
+// implementing io.Closer once the request is done, which frees the slot.
+type concurrencySlot chan struct{}
+
+func (s concurrencySlot) Close() error {
+	<-s
+	return nil
+}
+
+// Concurrency is an (opinionated) middleware that limits the number of requests
+// that are handled concurrently to max to protect downstream services. Unlike
+// fasthttp's Server.Concurrency, which limits connections, excess requests are
+// failed with a 429 error envelope, either right away or once they time out in
+// the queue as per the given options. A slot is held until the request is done.
+// It should be registered with Before(), or as the first middleware of a route.
+func Concurrency(max int, o ConcurrencyOptions) FastMiddleware {
+	var (
+		slots = make(concurrencySlot, max)
+		key   = fmt.Sprintf("__fastglue_concurrency_%p__", slots)
+	)
+
+	return func(r *Request) *Request {
+		select {
+		case slots <- struct{}{}:
+		default:
+			if !waitSlot(slots, o.QueueTimeout) {
+				_ = r.SendErrorEnvelope(fasthttp.StatusTooManyRequests, "Too many requests", nil, excepGeneral)
+				return nil
+			}
+		}
+
+		r.RequestCtx.SetUserValue(key, slots)
+		return r
+	}
+}
+
+// waitSlot waits for a free slot for the given duration and returns false if
+// there's none by then.
+func waitSlot(slots concurrencySlot, timeout time.Duration) bool {
+	if timeout <= 0 {
+		return false
+	}
+
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+
+	select {
+	case slots <- struct{}{}:
+		return true
+	case <-t.C:
+		return false
+	}
+}
@@ -0,0 +1,43 @@
+package fastglue
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/valyala/fasthttp"
+)
+
+func TestConcurrency(t *testing.T) {
+	newReq := func() *Request {
+		return &Request{RequestCtx: &fasthttp.RequestCtx{}}
+	}
+
+	mw := Concurrency(2, ConcurrencyOptions{})
+	r1, r2, r3 := newReq(), newReq(), newReq()
+	require.NotNil(t, mw(r1))
+	require.NotNil(t, mw(r2))
+
+	// The N+1th concurrent request is rejected.
+	require.Nil(t, mw(r3))
+	require.Equal(t, fasthttp.StatusTooManyRequests, r3.RequestCtx.Response.StatusCode())
+	require.Contains(t, string(r3.RequestCtx.Response.Body()), "Too many requests")
+
+	// The slot is freed once a request is done.
+	r1.RequestCtx.ResetUserValues()
+	require.NotNil(t, mw(newReq()))
+
+	// Queued requests wait for a slot.
+	mw = Concurrency(1, ConcurrencyOptions{QueueTimeout: time.Second})
+	r1 = newReq()
+	require.NotNil(t, mw(r1))
+	time.AfterFunc(time.Millisecond*50, r1.RequestCtx.ResetUserValues)
+	require.NotNil(t, mw(newReq()))
+
+	// and are rejected if they time out.
+	mw = Concurrency(1, ConcurrencyOptions{QueueTimeout: time.Millisecond * 50})
+	require.NotNil(t, mw(newReq()))
+	r3 = newReq()
+	require.Nil(t, mw(r3))
+	require.Equal(t, fasthttp.StatusTooManyRequests, r3.RequestCtx.Response.StatusCode())
+}
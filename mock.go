@@ -1,10 +1,14 @@
 package fastglue
 
 import (
+	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -15,9 +19,13 @@ import (
 // that can take an HTTP request and respond with a mock response.
 type MockServer struct {
 	Server  *httptest.Server
-	handles map[string]MockResponse
+	handles map[string]MockHandlerFunc
 }
 
+// MockHandlerFunc is a function that inspects an incoming request to the
+// mock server and returns a mock response.
+type MockHandlerFunc func(*http.Request) MockResponse
+
 // MockResponse represents a mock response produced by the mock server.
 type MockResponse struct {
 	StatusCode  int
@@ -25,6 +33,12 @@ type MockResponse struct {
 	Body        []byte
 }
 
+// MockRequestBuilder is a fluent builder for constructing a fastglue.Request
+// that can be passed to handlers to mock incoming HTTP requests.
+type MockRequestBuilder struct {
+	req *Request
+}
+
 // MockRequest represents a single mock request.
 type MockRequest struct {
 	server *MockServer
@@ -36,34 +50,40 @@ type MockRequest struct {
 // and the request can be responded to with a mock response.
 func NewMockServer() *MockServer {
 	m := &MockServer{
-		handles: make(map[string]MockResponse),
+		handles: make(map[string]MockHandlerFunc),
 	}
 	s := httptest.NewServer(
 		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			// Check if the URI is registered.
-			if _, ok := m.handles[r.RequestURI]; !ok {
+			// Check if the URI is registered. URIs are matched exactly
+			// first and then without the query string.
+			uri := r.RequestURI
+			if _, ok := m.handles[uri]; !ok {
+				uri = r.URL.Path
+			}
+			if _, ok := m.handles[uri]; !ok {
 				w.WriteHeader(http.StatusNotFound)
 				logerr(w.Write([]byte("not found")))
 				return
 			}
 
 			// Check if the method+URI is registered.
-			out, ok := m.handles[r.Method+r.RequestURI]
+			fn, ok := m.handles[r.Method+uri]
 			if !ok {
 				w.WriteHeader(http.StatusMethodNotAllowed)
 				logerr(w.Write([]byte("method not allowed")))
 				return
 			}
+			out := fn(r)
 
-			// Write the status code.
+			// Write the headers and the status code.
+			if out.ContentType != "" {
+				w.Header().Set("Content-Type", out.ContentType)
+			}
 			if out.StatusCode == 0 {
 				w.WriteHeader(200)
 			} else {
 				w.WriteHeader(out.StatusCode)
 			}
-			if out.ContentType != "" {
-				w.Header().Set("Content-Type", out.ContentType)
-			}
 			if len(out.Body) > 0 {
 				logerr(w.Write(out.Body))
 			}
@@ -73,21 +93,29 @@ func NewMockServer() *MockServer {
 	return m
 }
 
-// Handle registers a mock response handler.
+// Handle registers a static mock response handler.
 func (m *MockServer) Handle(method, uri string, r MockResponse) {
+	m.HandleFunc(method, uri, func(*http.Request) MockResponse {
+		return r
+	})
+}
+
+// HandleFunc registers a mock handler function that can inspect the incoming
+// request (body, query, headers) and respond dynamically.
+func (m *MockServer) HandleFunc(method, uri string, fn MockHandlerFunc) {
 	key := method + uri
 	_, ok := m.handles[key]
 	if ok {
 		panic(fmt.Sprintf("handle already registered: %v:%v", method, uri))
 	}
 
-	m.handles[key] = r
-	m.handles[uri] = r
+	m.handles[key] = fn
+	m.handles[uri] = fn
 }
 
 // Reset resets existing registered mock response handlers.
 func (m *MockServer) Reset() {
-	m.handles = make(map[string]MockResponse)
+	m.handles = make(map[string]MockHandlerFunc)
 }
 
 // URL returns the URL of the mock server that can be used as the mock
@@ -104,6 +132,72 @@ func (m *MockServer) NewFastglueReq() *Request {
 	}
 }
 
+// NewReq returns a MockRequestBuilder with which the method, URI, headers and body
+// of a mock incoming request can be set.
+//
+// Example:
+// req := m.NewReq().Method("POST").URI("/orders").JSONBody(order).Header("X-A", "b").Build()
+func (m *MockServer) NewReq() *MockRequestBuilder {
+	return &MockRequestBuilder{req: m.NewFastglueReq()}
+}
+
+// Method sets the HTTP method of the request.
+func (b *MockRequestBuilder) Method(method string) *MockRequestBuilder {
+	b.req.RequestCtx.Request.Header.SetMethod(method)
+	return b
+}
+
+// URI sets the request URI (path and query string) of the request.
+func (b *MockRequestBuilder) URI(uri string) *MockRequestBuilder {
+	b.req.RequestCtx.Request.SetRequestURI(uri)
+	return b
+}
+
+// Header sets a request header.
+func (b *MockRequestBuilder) Header(key, value string) *MockRequestBuilder {
+	b.req.RequestCtx.Request.Header.Set(key, value)
+	return b
+}
+
+// Body sets the request body along with its content type.
+func (b *MockRequestBuilder) Body(ctype string, body []byte) *MockRequestBuilder {
+	b.req.RequestCtx.Request.Header.SetContentType(ctype)
+	b.req.RequestCtx.Request.SetBody(body)
+	return b
+}
+
+// JSONBody marshals v to JSON and sets it as the request body
+// with the JSON content type. It panics if v can't be marshalled.
+func (b *MockRequestBuilder) JSONBody(v interface{}) *MockRequestBuilder {
+	j, err := json.Marshal(v)
+	if err != nil {
+		panic(fmt.Sprintf("error marshalling JSON body: %v", err))
+	}
+	return b.Body(JSON, j)
+}
+
+// FormBody sets url-encoded form values as the request body.
+func (b *MockRequestBuilder) FormBody(form url.Values) *MockRequestBuilder {
+	return b.Body("application/x-www-form-urlencoded", []byte(form.Encode()))
+}
+
+// UserValue sets a user value (eg: path params) on the request context.
+func (b *MockRequestBuilder) UserValue(key string, value interface{}) *MockRequestBuilder {
+	b.req.RequestCtx.SetUserValue(key, value)
+	return b
+}
+
+// Context sets the fastglue context on the request.
+func (b *MockRequestBuilder) Context(c interface{}) *MockRequestBuilder {
+	b.req.Context = c
+	return b
+}
+
+// Build returns the constructed fastglue.Request.
+func (b *MockRequestBuilder) Build() *Request {
+	return b.req
+}
+
 // Do returns a new request handler with which a mock request is made.
 // It takes an HTTP handler and executes it against the given request.
 // The assert.Assertions is optional.
@@ -158,6 +252,91 @@ func (mr *MockRequest) AssertJSON(body []byte) {
 		"response body doesn't match")
 }
 
+// AssertHeader asserts a response header of the request against the given value.
+func (mr *MockRequest) AssertHeader(key, value string) {
+	mr.assert.Equal(value, string(mr.req.RequestCtx.Response.Header.Peek(key)),
+		"response header `"+key+"` doesn't match")
+}
+
+// AssertContentType asserts the Content-Type of the response against the given value.
+func (mr *MockRequest) AssertContentType(ctype string) {
+	mr.assert.Equal(ctype, string(mr.req.RequestCtx.Response.Header.ContentType()),
+		"response content type doesn't match")
+}
+
+// AssertJSONPath decodes the JSON response body and asserts the value at the
+// given dotted path (eg: data.user.name or data.items.0.id) against the given value.
+func (mr *MockRequest) AssertJSONPath(path string, expected interface{}) {
+	var body interface{}
+	if !mr.assert.NoError(json.Unmarshal(mr.req.RequestCtx.Response.Body(), &body),
+		"response body is not valid JSON") {
+		return
+	}
+
+	got, err := lookupJSONPath(body, path)
+	if !mr.assert.NoError(err, "error looking up JSON path") {
+		return
+	}
+
+	// Normalize the expected value to its JSON decoded form so that
+	// values such as ints compare equal to decoded float64s.
+	var exp interface{}
+	b, err := json.Marshal(expected)
+	if !mr.assert.NoError(err, "error marshalling expected value") {
+		return
+	}
+	if !mr.assert.NoError(json.Unmarshal(b, &exp), "error unmarshalling expected value") {
+		return
+	}
+
+	mr.assert.Equal(exp, got, "value at JSON path `"+path+"` doesn't match")
+}
+
+// AssertErrorEnvelope decodes the response body as an Envelope and asserts that
+// it's an error envelope with the given response code and error type.
+func (mr *MockRequest) AssertErrorEnvelope(code int, errorType ErrorType) {
+	mr.AssertStatus(code)
+
+	var e Envelope
+	if !mr.assert.NoError(json.Unmarshal(mr.req.RequestCtx.Response.Body(), &e),
+		"response body is not a valid envelope") {
+		return
+	}
+
+	mr.assert.Equal("error", e.Status, "envelope status doesn't match")
+	if mr.assert.NotNil(e.ErrorType, "envelope has no error_type") {
+		mr.assert.Equal(errorType, *e.ErrorType, "envelope error_type doesn't match")
+	}
+}
+
+// lookupJSONPath looks up a dotted path in a decoded JSON value.
+func lookupJSONPath(v interface{}, path string) (interface{}, error) {
+	if path == "" {
+		return v, nil
+	}
+
+	for _, k := range strings.Split(path, ".") {
+		switch o := v.(type) {
+		case map[string]interface{}:
+			val, ok := o[k]
+			if !ok {
+				return nil, fmt.Errorf("key `%s` not found in path `%s`", k, path)
+			}
+			v = val
+		case []interface{}:
+			i, err := strconv.Atoi(k)
+			if err != nil || i < 0 || i >= len(o) {
+				return nil, fmt.Errorf("invalid index `%s` in path `%s`", k, path)
+			}
+			v = o[i]
+		default:
+			return nil, fmt.Errorf("can't lookup `%s` on a non-object in path `%s`", k, path)
+		}
+	}
+
+	return v, nil
+}
+
 func logerr(n int, err error) {
 	if err != nil {
 		log.Printf("Write failed: %v", err)
@@ -57,6 +57,100 @@ func TestMockServerReset(t *testing.T) {
 	})
 }
 
+func TestMockServerHandleFunc(t *testing.T) {
+	m := NewMockServer()
+
+	m.HandleFunc(fasthttp.MethodGet, "/test", func(r *http.Request) MockResponse {
+		if r.URL.Query().Get("type") == "error" {
+			return MockResponse{
+				StatusCode: fasthttp.StatusBadRequest,
+				Body:       []byte("bad request"),
+			}
+		}
+		return MockResponse{Body: []byte("hello " + r.URL.Query().Get("name"))}
+	})
+
+	req := m.NewFastglueReq()
+	req.RequestCtx.SetUserValue("mock_url", m.URL()+"/test?name=world")
+	mr := m.Do(handleMockRequest, req, t)
+	mr.AssertStatus(fasthttp.StatusOK)
+	mr.AssertBody([]byte("hello world"))
+
+	req = m.NewFastglueReq()
+	req.RequestCtx.SetUserValue("mock_url", m.URL()+"/test?type=error")
+	mr = m.Do(handleMockRequest, req, t)
+	mr.AssertStatus(fasthttp.StatusBadRequest)
+	mr.AssertBody([]byte("bad request"))
+}
+
+func TestMockRequestAssertions(t *testing.T) {
+	m := NewMockServer()
+
+	req := m.NewFastglueReq()
+	mr := m.Do(func(r *Request) error {
+		r.RequestCtx.Response.Header.Set("X-Request-Id", "abc")
+		return r.SendEnvelope(map[string]interface{}{
+			"user": map[string]interface{}{
+				"name": "tester",
+				"age":  30,
+				"tags": []string{"a", "b"},
+			},
+		})
+	}, req, t)
+
+	mr.AssertStatus(fasthttp.StatusOK)
+	mr.AssertHeader("X-Request-Id", "abc")
+	mr.AssertContentType(JSON)
+	mr.AssertJSONPath("status", "success")
+	mr.AssertJSONPath("data.user.name", "tester")
+	mr.AssertJSONPath("data.user.age", 30)
+	mr.AssertJSONPath("data.user.tags.1", "b")
+}
+
+func TestMockRequestAssertErrorEnvelope(t *testing.T) {
+	m := NewMockServer()
+
+	req := m.NewReq().
+		Method(fasthttp.MethodPost).
+		JSONBody(map[string]interface{}{"age": "thirty"}).
+		Build()
+
+	mr := m.Do(func(r *Request) error {
+		var p Person
+		if err := r.Decode(&p, "json"); err != nil {
+			return r.SendErrorEnvelope(fasthttp.StatusBadRequest, "Invalid request", nil, "InputException")
+		}
+		return r.SendEnvelope(p)
+	}, req, t)
+
+	mr.AssertErrorEnvelope(fasthttp.StatusBadRequest, "InputException")
+	mr.AssertJSONPath("message", "Invalid request")
+}
+
+func TestMockRequestBuilder(t *testing.T) {
+	m := NewMockServer()
+
+	req := m.NewReq().
+		Method(fasthttp.MethodPost).
+		URI("/person?param=123").
+		Header("X-Test", "yes").
+		JSONBody(Person{Name: "tester", Age: 30}).
+		Context(&App{version: "xxx"}).
+		Build()
+
+	mr := m.Do(func(r *Request) error {
+		if string(r.RequestCtx.Request.Header.Peek("X-Test")) != "yes" {
+			return r.SendErrorEnvelope(fasthttp.StatusBadRequest, "missing header", nil, "")
+		}
+		return myPOSTJsonhandler(r)
+	}, req, t)
+
+	mr.AssertStatus(fasthttp.StatusOK)
+	mr.AssertJSONPath("data.name", "tester")
+	mr.AssertJSONPath("data.age", 30)
+	mr.AssertJSONPath("data.version", "xxx")
+}
+
 // handleMockRequest is a dummy HTTP handler that sends a request
 // to the mock server URL and writes that response.
 func handleMockRequest(r *Request) error {
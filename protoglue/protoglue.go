@@ -0,0 +1,58 @@
+// Package protoglue adds protobuf request and response support to fastglue.
+// It's a separate module so that users who don't speak protobuf don't
+// have to pull in the protobuf dependency.
+package protoglue
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/valyala/fasthttp"
+	"github.com/zerodha/fastglue"
+	"google.golang.org/protobuf/proto"
+)
+
+// ContentType is the content type of protobuf payloads.
+const ContentType = "application/x-protobuf"
+
+// ErrContentType is returned by Decode when the request isn't a protobuf payload.
+var ErrContentType = errors.New("expected content type " + ContentType)
+
+// Send marshals the given message and writes it to the HTTP response
+// with the protobuf content type.
+func Send(r *fastglue.Request, code int, m proto.Message) error {
+	b, err := proto.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	return r.SendBytes(code, ContentType, b)
+}
+
+// Decode unmarshals the protobuf body of the request into the given message.
+func Decode(r *fastglue.Request, m proto.Message) error {
+	ct := r.RequestCtx.Request.Header.ContentType()
+	if i := bytes.IndexByte(ct, ';'); i >= 0 {
+		ct = ct[:i]
+	}
+	if string(bytes.TrimSpace(ct)) != ContentType {
+		return ErrContentType
+	}
+
+	return proto.Unmarshal(r.RequestCtx.PostBody(), m)
+}
+
+// DecodeFail unmarshals the protobuf body of the request into the given message
+// and sends a 400 error envelope if it fails, similar to fastglue's DecodeFail.
+func DecodeFail(r *fastglue.Request, m proto.Message) error {
+	if err := Decode(r, m); err != nil {
+		if errSend := r.SendErrorEnvelope(fasthttp.StatusBadRequest,
+			"Error unmarshalling request: `"+err.Error()+"`", nil, "InputException"); errSend != nil {
+			return errSend
+		}
+
+		return err
+	}
+
+	return nil
+}
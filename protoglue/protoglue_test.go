@@ -0,0 +1,48 @@
+package protoglue
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/valyala/fasthttp"
+	"github.com/zerodha/fastglue"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func TestSendDecode(t *testing.T) {
+	g := fastglue.NewGlue()
+	g.POST("/echo", func(r *fastglue.Request) error {
+		var m wrapperspb.StringValue
+		if err := DecodeFail(r, &m); err != nil {
+			return nil
+		}
+
+		return Send(r, fasthttp.StatusOK, wrapperspb.String("echo: "+m.GetValue()))
+	})
+
+	req := func(ctype string, body []byte) *fasthttp.RequestCtx {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.Header.SetMethod(fasthttp.MethodPost)
+		ctx.Request.Header.SetContentType(ctype)
+		ctx.Request.SetRequestURI("/echo")
+		ctx.Request.SetBody(body)
+		g.Handler()(ctx)
+		return ctx
+	}
+
+	b, err := proto.Marshal(wrapperspb.String("hello"))
+	require.NoError(t, err)
+
+	ctx := req(ContentType, b)
+	require.Equal(t, fasthttp.StatusOK, ctx.Response.StatusCode())
+	require.Equal(t, ContentType, string(ctx.Response.Header.ContentType()))
+
+	var out wrapperspb.StringValue
+	require.NoError(t, proto.Unmarshal(ctx.Response.Body(), &out))
+	require.Equal(t, "echo: hello", out.GetValue())
+
+	// Non protobuf payloads are rejected.
+	ctx = req(fastglue.JSON, []byte(`{}`))
+	require.Equal(t, fasthttp.StatusBadRequest, ctx.Response.StatusCode())
+}
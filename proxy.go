@@ -0,0 +1,150 @@
+package fastglue
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// hopHeaders are the hop-by-hop headers that are only meaningful for a single
+// connection and are not forwarded by proxies (RFC 7230, section 6.1).
+var hopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Proxy-Connection",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// proxyClients is a map of upstream scheme+host to their *fasthttp.HostClient
+// so that connections to upstreams are reused across requests.
+var proxyClients sync.Map
+
+// ProxyOptions represents the options for Request.Proxy.
+type ProxyOptions struct {
+	// Rewrite optionally rewrites the path of the request before it's
+	// appended to the target's path, for instance, to strip a prefix.
+	Rewrite func(path string) string
+
+	// Timeout is the maximum duration for the upstream to respond.
+	// Defaults to 30 seconds.
+	Timeout time.Duration
+
+	// Client is an optional HostClient to use for the upstream. By default,
+	// a shared client is created for every upstream host.
+	Client *fasthttp.HostClient
+}
+
+// Proxy forwards the request to the upstream target URL (eg: http://127.0.0.1:8080/api)
+// and writes the upstream's response to the request's response. The request's
+// path (rewritten with ProxyOptions.Rewrite) is appended to the target's path and
+// its query string is retained. Hop-by-hop headers are stripped in both directions
+// and the client's IP is appended to X-Forwarded-For.
+//
+// The upstream request isn't made if the request's StdContext() is already done
+// and it's cut short at the context's deadline.
+//
+// If the upstream can't be reached, a 502 error envelope (504 on timeouts)
+// is sent and the error is returned.
+func (r *Request) Proxy(target string, o ProxyOptions) error {
+	u, err := url.Parse(target)
+	if err != nil || u.Host == "" {
+		return r.SendErrorEnvelope(fasthttp.StatusInternalServerError, "Invalid proxy target", nil, excepGeneral)
+	}
+	if o.Timeout == 0 {
+		o.Timeout = time.Second * 30
+	}
+
+	hc := o.Client
+	if hc == nil {
+		hc = proxyClient(u)
+	}
+
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	r.RequestCtx.Request.CopyTo(req)
+
+	path := string(r.RequestCtx.Path())
+	if o.Rewrite != nil {
+		path = o.Rewrite(path)
+	}
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	req.URI().SetScheme(u.Scheme)
+	req.URI().SetHost(u.Host)
+	req.URI().SetPath(strings.TrimSuffix(u.Path, "/") + path)
+	req.Header.SetHost(u.Host)
+
+	stripHopHeaders(&req.Header)
+	if ip := r.RequestCtx.RemoteIP(); ip != nil && !ip.IsUnspecified() {
+		if prior := req.Header.Peek("X-Forwarded-For"); len(prior) > 0 {
+			req.Header.Set("X-Forwarded-For", string(prior)+", "+ip.String())
+		} else {
+			req.Header.Set("X-Forwarded-For", ip.String())
+		}
+	}
+
+	ctx := r.StdContext()
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("request aborted: %w", err)
+	}
+
+	resp := &r.RequestCtx.Response
+	if err := hc.DoDeadline(req, resp, ctxDeadline(ctx, o.Timeout)); err != nil {
+		resp.Reset()
+
+		code := fasthttp.StatusBadGateway
+		if errors.Is(err, fasthttp.ErrTimeout) {
+			code = fasthttp.StatusGatewayTimeout
+		}
+		if errSend := r.SendErrorEnvelope(code, "Error connecting to upstream", nil, excepGeneral); errSend != nil {
+			return errSend
+		}
+
+		return err
+	}
+
+	// Strip the upstream's hop-by-hop headers from the response.
+	for _, h := range hopHeaders {
+		resp.Header.Del(h)
+	}
+
+	return nil
+}
+
+// proxyClient returns the shared HostClient for the given upstream URL.
+func proxyClient(u *url.URL) *fasthttp.HostClient {
+	key := u.Scheme + "://" + u.Host
+	if hc, ok := proxyClients.Load(key); ok {
+		return hc.(*fasthttp.HostClient)
+	}
+
+	hc, _ := proxyClients.LoadOrStore(key, &fasthttp.HostClient{
+		Addr:  u.Host,
+		IsTLS: u.Scheme == "https",
+	})
+	return hc.(*fasthttp.HostClient)
+}
+
+// stripHopHeaders removes the hop-by-hop headers from a request header,
+// including the ones listed in its Connection header.
+func stripHopHeaders(h *fasthttp.RequestHeader) {
+	for _, c := range strings.Split(string(h.Peek("Connection")), ",") {
+		if c = strings.TrimSpace(c); c != "" {
+			h.Del(c)
+		}
+	}
+	for _, name := range hopHeaders {
+		h.Del(name)
+	}
+}
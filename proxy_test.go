@@ -0,0 +1,59 @@
+package fastglue
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/valyala/fasthttp"
+)
+
+func TestProxy(t *testing.T) {
+	m := NewMockServer()
+	defer m.Server.Close()
+
+	var upstream *http.Request
+	m.HandleFunc(fasthttp.MethodPost, "/api/users", func(r *http.Request) MockResponse {
+		upstream = r
+		return MockResponse{StatusCode: fasthttp.StatusCreated, ContentType: JSON, Body: []byte(`{"id":1}`)}
+	})
+
+	g := NewGlue()
+	g.POST("/gw/{path:*}", func(r *Request) error {
+		return r.Proxy(m.URL()+"/api", ProxyOptions{
+			Rewrite: func(p string) string {
+				return strings.TrimPrefix(p, "/gw")
+			},
+		})
+	})
+	g.GET("/down", func(r *Request) error {
+		return r.Proxy("http://127.0.0.1:1", ProxyOptions{})
+	})
+
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Request.Header.SetMethod(fasthttp.MethodPost)
+	ctx.Request.SetRequestURI("/gw/users?a=1")
+	ctx.Request.Header.Set("X-Custom", "yes")
+	ctx.Request.Header.Set("X-Hop", "1")
+	ctx.Request.Header.Set("Connection", "X-Hop")
+	ctx.Request.Header.Set("Proxy-Authorization", "secret")
+	g.Handler()(ctx)
+
+	require.Equal(t, fasthttp.StatusCreated, ctx.Response.StatusCode())
+	require.Equal(t, JSON, string(ctx.Response.Header.ContentType()))
+	require.Equal(t, `{"id":1}`, string(ctx.Response.Body()))
+
+	// The path is rewritten and hop-by-hop headers are stripped.
+	require.NotNil(t, upstream)
+	require.Equal(t, "a=1", upstream.URL.RawQuery)
+	require.Equal(t, "yes", upstream.Header.Get("X-Custom"))
+	require.Empty(t, upstream.Header.Get("X-Hop"))
+	require.Empty(t, upstream.Header.Get("Proxy-Authorization"))
+
+	// Unreachable upstream.
+	ctx = &fasthttp.RequestCtx{}
+	ctx.Request.SetRequestURI("/down")
+	g.Handler()(ctx)
+	require.Equal(t, fasthttp.StatusBadGateway, ctx.Response.StatusCode())
+}
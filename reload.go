@@ -0,0 +1,123 @@
+//go:build !windows
+// +build !windows
+
+package fastglue
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"syscall"
+
+	"github.com/valyala/fasthttp"
+)
+
+// envListenerFD is the environment variable with which the file descriptor
+// of a listener is handed over to a new process on reload.
+const envListenerFD = "FASTGLUE_LISTENER_FD"
+
+// InheritedListener returns the listener that was handed over by a parent process
+// during a reload (see ServeAndReload). It returns nil if there's no inherited listener,
+// in which case a new listener should be created.
+func InheritedListener() (net.Listener, error) {
+	v := os.Getenv(envListenerFD)
+	if v == "" {
+		return nil, nil
+	}
+
+	fd, err := strconv.Atoi(v)
+	if err != nil {
+		return nil, fmt.Errorf("invalid listener fd %s: %v", v, err)
+	}
+
+	f := os.NewFile(uintptr(fd), "fastglue-listener")
+	defer f.Close()
+
+	ln, err := net.FileListener(f)
+	if err != nil {
+		return nil, fmt.Errorf("error inheriting listener: %v", err)
+	}
+
+	// Don't pass the listener on to any further child processes unless reloading.
+	os.Unsetenv(envListenerFD)
+	return ln, nil
+}
+
+// StartProcess starts a new instance of the current executable with the same arguments
+// and environment, and hands the given listener over to it. The new process can pick
+// up the listener with InheritedListener().
+func StartProcess(ln net.Listener) (*os.Process, error) {
+	fl, ok := ln.(interface {
+		File() (*os.File, error)
+	})
+	if !ok {
+		return nil, errors.New("listener doesn't support handing over its file")
+	}
+
+	f, err := fl.File()
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	bin, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+
+	// ExtraFiles start at fd 3 after stdin, stdout and stderr.
+	cmd := exec.Command(bin, os.Args[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = []*os.File{f}
+	cmd.Env = append(os.Environ(), envListenerFD+"=3")
+
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return cmd.Process, nil
+}
+
+// ServeAndReload serves on the given listener (which can be from InheritedListener())
+// and on receiving any of the given signals (SIGHUP by default), hands the listener over
+// to a new instance of the program with StartProcess() and then gracefully drains and
+// shuts down the server. This allows reloads without dropping connections. If the new
+// process fails to start, the error is logged and the server continues serving.
+func (f *Fastglue) ServeAndReload(ln net.Listener, s *fasthttp.Server, sigs ...os.Signal) error {
+	if len(sigs) == 0 {
+		sigs = []os.Signal{syscall.SIGHUP}
+	}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, sigs...)
+	defer signal.Stop(sig)
+
+	return f.serveAndHandoff(ln, s, sig, func(ln net.Listener) error {
+		_, err := StartProcess(ln)
+		return err
+	})
+}
+
+// serveAndHandoff serves on ln and on a signal on `trigger`, runs handoff and
+// gracefully shuts down the server if it succeeds.
+func (f *Fastglue) serveAndHandoff(ln net.Listener, s *fasthttp.Server, trigger <-chan os.Signal, handoff func(net.Listener) error) error {
+	shutdown := make(chan struct{}, 1)
+	go func() {
+		for range trigger {
+			if err := handoff(ln); err != nil {
+				log.Printf("error handing over listener on reload: %v", err)
+				continue
+			}
+			shutdown <- struct{}{}
+			return
+		}
+	}()
+
+	return f.ServeGracefully(ln, s, shutdown)
+}
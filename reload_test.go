@@ -0,0 +1,58 @@
+//go:build !windows
+// +build !windows
+
+package fastglue
+
+import (
+	"net"
+	"net/http"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/valyala/fasthttp"
+)
+
+func TestServeAndHandoff(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	g := NewGlue()
+	g.GET("/", func(r *Request) error {
+		time.Sleep(500 * time.Millisecond)
+		return r.SendEnvelope(true)
+	})
+
+	var (
+		trigger  = make(chan os.Signal, 1)
+		handedLn = make(chan net.Listener, 1)
+		done     = make(chan error, 1)
+	)
+	go func() {
+		done <- g.serveAndHandoff(ln, nil, trigger, func(l net.Listener) error {
+			handedLn <- l
+			return nil
+		})
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	// Fire an in-flight request that should complete during the drain.
+	status := make(chan int, 1)
+	go func() {
+		resp, err := http.Get("http://" + ln.Addr().String() + "/")
+		if err != nil {
+			status <- 0
+			return
+		}
+		resp.Body.Close()
+		status <- resp.StatusCode
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	trigger <- syscall.SIGHUP
+	require.Equal(t, ln, <-handedLn)
+	require.NoError(t, <-done)
+	require.Equal(t, fasthttp.StatusOK, <-status)
+}
@@ -0,0 +1,200 @@
+package fastglue
+
+import (
+	"bytes"
+	"sync"
+	"time"
+
+	fasthttprouter "github.com/fasthttp/router"
+	"github.com/valyala/fasthttp"
+)
+
+// RouteOptions are per-route overrides of the server's limits that can optionally
+// be passed when registering a route, for instance, for file uploads that need
+// a larger body limit and more time than other endpoints:
+//
+//	g.POST("/upload", h, fastglue.RouteOptions{MaxBodySize: 100 << 20, Timeout: 2 * time.Minute})
+//
+// They're applied by fasthttp once the request's headers are read and before
+// the body is read with the server's HeaderReceived hook. As the body is read
+// before a request is routed, method overrides (SetMethodOverride) don't apply.
+type RouteOptions struct {
+	// MaxBodySize overrides the server's MaxRequestBodySize for the route.
+	MaxBodySize int
+
+	// Timeout overrides the server's ReadTimeout (for reading the body)
+	// and WriteTimeout for the route.
+	Timeout time.Duration
+}
+
+// routeOptionsSet holds the RouteOptions of the routes of a router keyed by their
+// method and route pattern, along with a router that matches request paths to
+// those patterns before the requests are routed.
+type routeOptionsSet struct {
+	opts   map[string]RouteOptions
+	router *fasthttprouter.Router
+}
+
+// matchCtxPool is the pool of the RequestCtxs in which the paths of requests are
+// matched to the route patterns of route options. Only their user values are used.
+var matchCtxPool = sync.Pool{
+	New: func() interface{} {
+		return &fasthttp.RequestCtx{}
+	},
+}
+
+func newRouteOptionsSet() *routeOptionsSet {
+	r := fasthttprouter.New()
+	r.SaveMatchedRoutePath = true
+
+	return &routeOptionsSet{
+		opts:   make(map[string]RouteOptions),
+		router: r,
+	}
+}
+
+// set registers the options of the route.
+func (rs *routeOptionsSet) set(method, path string, o RouteOptions) {
+	if _, ok := rs.opts[method+" "+path]; !ok {
+		rs.router.Handle(method, path, func(*fasthttp.RequestCtx) {})
+	}
+	rs.opts[method+" "+path] = o
+}
+
+// match returns the options of the route that matches the method and path, if any.
+func (rs *routeOptionsSet) match(method, path string) (RouteOptions, bool) {
+	if rs == nil {
+		return RouteOptions{}, false
+	}
+
+	ctx := matchCtxPool.Get().(*fasthttp.RequestCtx)
+	defer func() {
+		ctx.ResetUserValues()
+		matchCtxPool.Put(ctx)
+	}()
+
+	fn, _ := rs.router.Lookup(method, path, ctx)
+	if fn == nil {
+		return RouteOptions{}, false
+	}
+	fn(ctx)
+
+	pattern, _ := ctx.UserValue(fasthttprouter.MatchedRoutePathParam).(string)
+	if o, ok := rs.opts[method+" "+pattern]; ok {
+		return o, true
+	}
+	o, ok := rs.opts[fasthttprouter.MethodWild+" "+pattern]
+	return o, ok
+}
+
+// setRouteOptions registers the options of a route, if there are any.
+func (f *Fastglue) setRouteOptions(method, path string, o []RouteOptions) {
+	if len(o) == 0 {
+		return
+	}
+	if f.routeOptions == nil {
+		f.routeOptions = newRouteOptionsSet()
+	}
+	f.routeOptions.set(method, path, o[0])
+}
+
+// hasRouteOptions checks whether any of the routes has options.
+func (f *Fastglue) hasRouteOptions() bool {
+	if f.routeOptions != nil {
+		return true
+	}
+	for _, h := range f.hosts {
+		if h.options != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// lookupRouteOptions returns the options of the route that the request
+// with the given headers is routed to, if it has any.
+func (f *Fastglue) lookupRouteOptions(h *fasthttp.RequestHeader) (RouteOptions, bool) {
+	var (
+		method = string(h.Method())
+		path   = h.RequestURI()
+	)
+	if i := bytes.IndexByte(path, '?'); i >= 0 {
+		path = path[:i]
+	}
+	if f.caseInsensitive {
+		path = lowerASCII(path)
+	}
+
+	// Requests to hosts with a HostRouter whose routes don't match
+	// are routed with the global routes.
+	if len(f.hosts) > 0 {
+		host := hostname(h.Host())
+		for _, hr := range f.hosts {
+			if !hr.matches(host) {
+				continue
+			}
+			if fn, _ := hr.router.Lookup(method, string(path), nil); fn != nil {
+				return hr.options.match(method, string(path))
+			}
+			break
+		}
+	}
+
+	return f.routeOptions.match(method, string(path))
+}
+
+// applyRouteOptions sets the server's HeaderReceived hook that applies
+// the route options, retaining an existing hook, if there's one. The hook
+// is only set once on a server.
+func (f *Fastglue) applyRouteOptions(s *fasthttp.Server) {
+	if _, ok := f.optionServers[s]; ok {
+		return
+	}
+	if f.optionServers == nil {
+		f.optionServers = make(map[*fasthttp.Server]struct{})
+	}
+	f.optionServers[s] = struct{}{}
+
+	var (
+		hook = s.HeaderReceived
+
+		// fasthttp carries the config returned for a request over to the subsequent
+		// requests on the same connection, so the defaults are always returned.
+		def = fasthttp.RequestConfig{
+			ReadTimeout:        s.ReadTimeout,
+			WriteTimeout:       s.WriteTimeout,
+			MaxRequestBodySize: s.MaxRequestBodySize,
+		}
+	)
+	if def.MaxRequestBodySize <= 0 {
+		def.MaxRequestBodySize = fasthttp.DefaultMaxRequestBodySize
+	}
+
+	s.HeaderReceived = func(h *fasthttp.RequestHeader) fasthttp.RequestConfig {
+		c := def
+		if hook != nil {
+			hc := hook(h)
+			if hc.ReadTimeout > 0 {
+				c.ReadTimeout = hc.ReadTimeout
+			}
+			if hc.WriteTimeout > 0 {
+				c.WriteTimeout = hc.WriteTimeout
+			}
+			if hc.MaxRequestBodySize > 0 {
+				c.MaxRequestBodySize = hc.MaxRequestBodySize
+			}
+		}
+
+		if o, ok := f.lookupRouteOptions(h); ok {
+			if o.Timeout > 0 {
+				c.ReadTimeout = o.Timeout
+				c.WriteTimeout = o.Timeout
+			}
+			if o.MaxBodySize > 0 {
+				c.MaxRequestBodySize = o.MaxBodySize
+			}
+		}
+
+		return c
+	}
+}
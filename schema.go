@@ -0,0 +1,54 @@
+package fastglue
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/valyala/fasthttp"
+)
+
+// SchemaValidator validates JSON documents against a compiled JSON Schema.
+// It keeps the schema library pluggable. For instance, a validator backed by
+// github.com/xeipuuv/gojsonschema would load the schema once and return the
+// descriptions of the result's errors from Validate.
+type SchemaValidator interface {
+	// Validate validates the JSON document and returns the list of
+	// violations. An error is returned if the document can't be validated
+	// at all, for instance, if it's malformed.
+	Validate(doc []byte) ([]string, error)
+}
+
+// ValidateSchema is an (opinionated) middleware that validates the JSON bodies
+// of requests against the schema of the given validator before the handler runs.
+// Requests that don't have a JSON body are passed through. Invalid bodies are
+// rejected with a 400 error envelope with the list of violations in `data`.
+// It should be registered with Before().
+func ValidateSchema(v SchemaValidator) FastMiddleware {
+	return func(r *Request) *Request {
+		if !bytes.Contains(r.RequestCtx.Request.Header.ContentType(), constJSON) {
+			return r
+		}
+
+		body := r.RawBody()
+		if len(body) == 0 {
+			return r
+		}
+
+		if !json.Valid(body) {
+			_ = r.SendErrorEnvelope(fasthttp.StatusBadRequest, "Invalid JSON", nil, excepBadRequest)
+			return nil
+		}
+
+		violations, err := v.Validate(body)
+		if err != nil {
+			_ = r.SendErrorEnvelope(fasthttp.StatusBadRequest, "Error validating JSON: "+err.Error(), nil, excepBadRequest)
+			return nil
+		}
+		if len(violations) > 0 {
+			_ = r.SendErrorEnvelope(fasthttp.StatusBadRequest, "Request body doesn't match the schema", violations, excepBadRequest)
+			return nil
+		}
+
+		return r
+	}
+}
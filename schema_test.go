@@ -0,0 +1,59 @@
+package fastglue
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/valyala/fasthttp"
+)
+
+// requiredFields is a minimal SchemaValidator that checks for required fields.
+type requiredFields []string
+
+func (rf requiredFields) Validate(doc []byte) ([]string, error) {
+	var m map[string]interface{}
+	if err := json.Unmarshal(doc, &m); err != nil {
+		return nil, err
+	}
+
+	var out []string
+	for _, f := range rf {
+		if _, ok := m[f]; !ok {
+			out = append(out, f+" is required")
+		}
+	}
+	return out, nil
+}
+
+func TestValidateSchema(t *testing.T) {
+	g := NewGlue()
+	g.Before(ValidateSchema(requiredFields{"name", "age"}))
+	g.POST("/users", func(r *Request) error {
+		return r.SendEnvelope("created")
+	})
+
+	req := func(body string) *fasthttp.RequestCtx {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.Header.SetMethod(fasthttp.MethodPost)
+		ctx.Request.Header.SetContentType(JSON)
+		ctx.Request.SetRequestURI("/users")
+		ctx.Request.SetBodyString(body)
+		g.Handler()(ctx)
+		return ctx
+	}
+
+	// Valid body.
+	ctx := req(`{"name": "test", "age": 30}`)
+	require.Equal(t, fasthttp.StatusOK, ctx.Response.StatusCode())
+
+	// Invalid body.
+	ctx = req(`{"name": "test"}`)
+	require.Equal(t, fasthttp.StatusBadRequest, ctx.Response.StatusCode())
+	require.JSONEq(t, `{"status": "error", "message": "Request body doesn't match the schema",
+		"error_type": "InputException", "data": ["age is required"]}`, string(ctx.Response.Body()))
+
+	// Malformed body.
+	ctx = req(`{"name":`)
+	require.Equal(t, fasthttp.StatusBadRequest, ctx.Response.StatusCode())
+}
@@ -1,44 +1,120 @@
 package fastglue
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"math"
 	"reflect"
+	"sort"
 	"strconv"
 	"strings"
 
 	"github.com/valyala/fasthttp"
 )
 
+// ArgUnmarshaler is the interface implemented by types that can unmarshal
+// a single form/query arg value of themselves. ScanArgs uses it in
+// preference to the kind based parsing of values.
+type ArgUnmarshaler interface {
+	UnmarshalArg(string) error
+}
+
+var argUnmarshalerType = reflect.TypeOf((*ArgUnmarshaler)(nil)).Elem()
+
 // ScanArgs takes a fasthttp.Args set, takes its keys and values
 // and applies them to a given struct using reflection. The field names
 // are mapped to the struct fields based on a given tag tag. The field
 // names that have been mapped are also return as a list. Supports string,
-// bool, number types and their slices.
+// bool, number types and their slices, and types that implement ArgUnmarshaler.
+// The tagged fields of untagged embedded structs are scanned as if they were
+// fields of the struct itself.
 //
 // eg:
 //
 //	type Order struct {
 //		Tradingsymbol string `url:"tradingsymbol"`
 //		Tags []string `url:"tag"`
+//		Sort string `url:"sort,oneof=asc desc"`
 //	}
+//
+// The `oneof` attribute takes a space separated list of allowed values and
+// values outside the list are rejected. Fields with the `omitempty` attribute
+// are left out of the returned list of fields if their scanned values are
+// empty (as defined by encoding/json), which is useful for partial updates.
+// The values of slice fields with the `csv` attribute (eg: `url:"ids,csv"`) are
+// split on commas, so ?ids=1,2,3 is scanned the same as ?ids=1&ids=2&ids=3.
 func ScanArgs(args *fasthttp.Args, obj interface{}, fieldTag string) ([]string, error) {
+	return scanArgs(args, obj, fieldTag, false)
+}
+
+// ScanArgsAll is the same as ScanArgs but instead of failing on the first bad field,
+// it scans all the fields and returns the errors of every bad field as FieldErrors.
+func ScanArgsAll(args *fasthttp.Args, obj interface{}, fieldTag string) ([]string, error) {
+	return scanArgs(args, obj, fieldTag, true)
+}
+
+// FieldErrors is a map of field names to their decoding errors.
+type FieldErrors map[string]error
+
+// Error returns the errors of all the fields sorted by the field names.
+func (fe FieldErrors) Error() string {
+	keys := make([]string, 0, len(fe))
+	for k := range fe {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	out := make([]string, 0, len(keys))
+	for _, k := range keys {
+		out = append(out, fe[k].Error())
+	}
+	return strings.Join(out, "; ")
+}
+
+func scanArgs(args *fasthttp.Args, obj interface{}, fieldTag string, all bool) ([]string, error) {
 	ob := reflect.ValueOf(obj)
 	if ob.Kind() == reflect.Ptr {
 		ob = ob.Elem()
 	}
 
+	// Form values are flat key-value pairs and can't be scanned into a list of structs.
+	if ob.Kind() == reflect.Slice || ob.Kind() == reflect.Array {
+		return nil, fmt.Errorf("failed to decode form values, can't decode into slice or array type: %T", obj)
+	}
+
 	if ob.Kind() != reflect.Struct {
-		return nil, fmt.Errorf("failed to decode form values to struct, received non struct type: %T", ob)
+		return nil, fmt.Errorf("failed to decode form values to struct, received non struct type: %T", obj)
 	}
 
 	// Go through every field in the struct and look for it in the Args map.
-	var fields []string
+	var (
+		fields []string
+		errs   = FieldErrors{}
+	)
 	for i := 0; i < ob.NumField(); i++ {
 		f := ob.Field(i)
 		if f.IsValid() && f.CanSet() {
 			tag := ob.Type().Field(i).Tag.Get(fieldTag)
+
+			// Untagged embedded structs are flattened into the same namespace.
+			if tag == "" && ob.Type().Field(i).Anonymous {
+				fl, err := scanEmbedded(args, f, fieldTag, all)
+				fields = append(fields, fl...)
+				if err != nil {
+					fe, ok := err.(FieldErrors)
+					if !all || !ok {
+						return nil, err
+					}
+					for k, v := range fe {
+						errs[k] = v
+					}
+				}
+				continue
+			}
+
 			if tag == "" || tag == "-" {
 				continue
 			}
@@ -46,60 +122,137 @@ func ScanArgs(args *fasthttp.Args, obj interface{}, fieldTag string) ([]string,
 			// Got a struct field with a tag.
 			// If that field exists in the arg and convert its type.
 			// Tags are of the type `tagname,attribute`
-			tag = strings.Split(tag, ",")[0]
+			var (
+				attrs     = strings.Split(tag, ",")
+				oneOf     []string
+				omitEmpty bool
+				csv       bool
+			)
+			tag = attrs[0]
 			if !args.Has(tag) {
 				continue
 			}
 
-			var (
-				scanned bool
-				err     error
-			)
-			// The struct field is a slice type.
-			if f.Kind() == reflect.Slice {
-				var (
-					vals    = args.PeekMulti(tag)
-					numVals = len(vals)
-				)
-
-				// Make a slice.
-				sl := reflect.MakeSlice(f.Type(), numVals, numVals)
-
-				// If it's a []byte slice (=[]uint8), assign here.
-				if f.Type().Elem().Kind() == reflect.Uint8 {
-					br := args.Peek(tag)
-					b := make([]byte, len(br))
-					copy(b, br)
-					f.SetBytes(b)
-					continue
+			for _, a := range attrs[1:] {
+				if strings.HasPrefix(a, "oneof=") {
+					oneOf = strings.Fields(strings.TrimPrefix(a, "oneof="))
+				} else if a == "omitempty" {
+					omitEmpty = true
+				} else if a == "csv" {
+					csv = true
 				}
+			}
 
-				// Iterate through fasthttp's multiple args and assign values
-				// to each item in the slice.
-				for i, v := range vals {
-					scanned, err = setVal(sl.Index(i), string(v))
-					if err != nil {
-						return nil, fmt.Errorf("failed to decode `%v`, got: `%s` (%v)", tag, v, err)
-					}
-				}
-				f.Set(sl)
-			} else {
-				v := string(args.Peek(tag))
-				scanned, err = setVal(f, v)
-				if err != nil {
-					return nil, fmt.Errorf("failed to decode `%v`, got: `%s` (%v)", tag, v, err)
+			scanned, err := scanField(args, f, tag, oneOf, csv)
+			if err != nil {
+				if !all {
+					return nil, err
 				}
+				errs[tag] = err
+				continue
 			}
 
-			if scanned {
+			// Fields with `omitempty` are scanned, but not reported if their values are empty.
+			if scanned && !(omitEmpty && isEmptyValue(f)) {
 				fields = append(fields, tag)
 			}
 		}
 	}
+
+	if len(errs) > 0 {
+		return fields, errs
+	}
 	return fields, nil
 }
 
+// scanEmbedded scans args into the fields of an embedded struct (or a pointer to one).
+// A nil pointer is only allocated if one of its fields is scanned.
+func scanEmbedded(args *fasthttp.Args, f reflect.Value, fieldTag string, all bool) ([]string, error) {
+	switch {
+	case f.Kind() == reflect.Struct && !isArgUnmarshaler(f):
+		return scanArgs(args, f.Addr().Interface(), fieldTag, all)
+
+	case f.Kind() == reflect.Ptr && f.Type().Elem().Kind() == reflect.Struct:
+		v := f
+		if f.IsNil() {
+			v = reflect.New(f.Type().Elem())
+		}
+
+		fields, err := scanArgs(args, v.Interface(), fieldTag, all)
+		if f.IsNil() && len(fields) > 0 {
+			f.Set(v)
+		}
+		return fields, err
+	}
+
+	return nil, nil
+}
+
+// scanField scans the values of the arg `tag` into the struct field f. If csv is set,
+// the values of slice fields are split on commas.
+func scanField(args *fasthttp.Args, f reflect.Value, tag string, oneOf []string, csv bool) (bool, error) {
+	// The struct field is not a slice type.
+	if f.Kind() != reflect.Slice || isArgUnmarshaler(f) {
+		v := string(args.Peek(tag))
+		if err := checkOneOf(v, oneOf); err != nil {
+			return false, fmt.Errorf("failed to decode `%v`, got: `%s` (%v)", tag, v, err)
+		}
+
+		scanned, err := setVal(f, v)
+		if err != nil {
+			return false, fmt.Errorf("failed to decode `%v`, got: `%s` (%v)", tag, v, err)
+		}
+		return scanned, nil
+	}
+
+	// If it's a []byte slice (=[]uint8), assign here.
+	if f.Type().Elem().Kind() == reflect.Uint8 {
+		br := args.Peek(tag)
+		b := make([]byte, len(br))
+		copy(b, br)
+		f.SetBytes(b)
+		return false, nil
+	}
+
+	var (
+		vals    = args.PeekMulti(tag)
+		scanned bool
+	)
+	if csv {
+		vals = splitCSV(vals)
+	}
+	numVals := len(vals)
+
+	// Make a slice.
+	sl := reflect.MakeSlice(f.Type(), numVals, numVals)
+
+	// Iterate through fasthttp's multiple args and assign values
+	// to each item in the slice.
+	for i, v := range vals {
+		if err := checkOneOf(string(v), oneOf); err != nil {
+			return false, fmt.Errorf("failed to decode `%v`, got: `%s` (%v)", tag, v, err)
+		}
+
+		ok, err := setVal(sl.Index(i), string(v))
+		if err != nil {
+			return false, fmt.Errorf("failed to decode `%v`, got: `%s` (%v)", tag, v, err)
+		}
+		scanned = ok
+	}
+	f.Set(sl)
+
+	return scanned, nil
+}
+
 func setVal(f reflect.Value, val string) (bool, error) {
+	// If the type knows how to unmarshal itself, use that.
+	if isArgUnmarshaler(f) {
+		if err := f.Addr().Interface().(ArgUnmarshaler).UnmarshalArg(val); err != nil {
+			return false, err
+		}
+		return true, nil
+	}
+
 	switch f.Kind() {
 	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
 		v, err := strconv.ParseInt(val, 10, 0)
@@ -142,7 +295,7 @@ func setVal(f reflect.Value, val string) (bool, error) {
 
 		return false, nil
 	case reflect.Ptr:
-		if f.Type().Elem().Kind() == reflect.Struct {
+		if f.Type().Elem().Kind() == reflect.Struct && !reflect.PtrTo(f.Type().Elem()).Implements(argUnmarshalerType) {
 			typ := f.Type().Elem()
 			receiver := reflect.New(typ).Interface()
 
@@ -177,3 +330,277 @@ func setVal(f reflect.Value, val string) (bool, error) {
 	}
 	return true, nil
 }
+
+// checkOneOf checks whether val is one of the allowed values.
+// An empty list allows all values.
+func checkOneOf(val string, allowed []string) error {
+	if len(allowed) == 0 {
+		return nil
+	}
+
+	for _, a := range allowed {
+		if val == a {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("expected one of: %s", strings.Join(allowed, ", "))
+}
+
+// isEmptyValue checks whether a value is empty the same way
+// encoding/json does for `omitempty`.
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	}
+	return false
+}
+
+// isArgUnmarshaler checks whether an addressable value implements ArgUnmarshaler.
+func isArgUnmarshaler(f reflect.Value) bool {
+	return f.CanAddr() && reflect.PtrTo(f.Type()).Implements(argUnmarshalerType)
+}
+
+// argsMap copies all the args into a map of keys to their values.
+func argsMap(args *fasthttp.Args) map[string][]string {
+	out := make(map[string][]string, args.Len())
+	args.VisitAll(func(k, v []byte) {
+		out[string(k)] = append(out[string(k)], string(v))
+	})
+	return out
+}
+
+// argString returns the value of the arg key or def if it doesn't exist.
+func argString(args *fasthttp.Args, key string, def string) string {
+	if !args.Has(key) {
+		return def
+	}
+	return string(args.Peek(key))
+}
+
+// argInt returns the value of the arg key as an int or def
+// if it doesn't exist or can't be parsed.
+func argInt(args *fasthttp.Args, key string, def int) int {
+	v, err := strconv.Atoi(string(args.Peek(key)))
+	if err != nil {
+		return def
+	}
+	return v
+}
+
+// argFloat returns the value of the arg key as a float64 or def
+// if it doesn't exist or can't be parsed.
+func argFloat(args *fasthttp.Args, key string, def float64) float64 {
+	v, err := strconv.ParseFloat(string(args.Peek(key)), 64)
+	if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+		return def
+	}
+	return v
+}
+
+// argBool returns the value of the arg key as a bool or def
+// if it doesn't exist or can't be parsed.
+func argBool(args *fasthttp.Args, key string, def bool) bool {
+	v, err := strconv.ParseBool(string(args.Peek(key)))
+	if err != nil {
+		return def
+	}
+	return v
+}
+
+// joinedErrors is a list of errors that's compatible with errors.Join (Go 1.20+)
+// while supporting older versions of Go.
+type joinedErrors []error
+
+func (e joinedErrors) Error() string {
+	out := make([]string, len(e))
+	for i, err := range e {
+		out[i] = err.Error()
+	}
+	return strings.Join(out, "\n")
+}
+
+// Unwrap returns the errors for errors.Is and errors.As.
+func (e joinedErrors) Unwrap() []error {
+	return e
+}
+
+// joinErrors returns an error that wraps the non-nil errors in errs, the error
+// itself if there's only one, or nil if there are none.
+func joinErrors(errs ...error) error {
+	var out joinedErrors
+	for _, err := range errs {
+		if err != nil {
+			out = append(out, err)
+		}
+	}
+
+	switch len(out) {
+	case 0:
+		return nil
+	case 1:
+		return out[0]
+	}
+	return out
+}
+
+// overrideMethod sets the method of a POST request to the one in its
+// X-HTTP-Method-Override header or `_method` form field, if it's allowed.
+func overrideMethod(ctx *fasthttp.RequestCtx) {
+	if !ctx.IsPost() {
+		return
+	}
+
+	m := ctx.Request.Header.Peek("X-HTTP-Method-Override")
+	if len(m) == 0 && bytes.HasPrefix(ctx.Request.Header.ContentType(), constForm) {
+		m = ctx.PostArgs().Peek("_method")
+	}
+
+	switch strings.ToUpper(string(m)) {
+	case fasthttp.MethodPut:
+		ctx.Request.Header.SetMethod(fasthttp.MethodPut)
+	case fasthttp.MethodPatch:
+		ctx.Request.Header.SetMethod(fasthttp.MethodPatch)
+	case fasthttp.MethodDelete:
+		ctx.Request.Header.SetMethod(fasthttp.MethodDelete)
+	}
+}
+
+// mediaType returns the lowercased media type of a Content-Type
+// header without its parameters, eg: text/csv for "text/CSV; charset=utf-8".
+func mediaType(ct []byte) string {
+	if i := bytes.IndexByte(ct, ';'); i >= 0 {
+		ct = ct[:i]
+	}
+	return strings.ToLower(string(bytes.TrimSpace(ct)))
+}
+
+// splitCSV splits comma separated values (eg: 1,2,3) into individual
+// values, dropping the empty ones.
+func splitCSV(vals [][]byte) [][]byte {
+	out := make([][]byte, 0, len(vals))
+	for _, v := range vals {
+		for _, p := range bytes.Split(v, []byte(",")) {
+			if p = bytes.TrimSpace(p); len(p) > 0 {
+				out = append(out, p)
+			}
+		}
+	}
+	return out
+}
+
+// hasUpper checks whether b has ASCII uppercase characters.
+func hasUpper(b []byte) bool {
+	for _, c := range b {
+		if c >= 'A' && c <= 'Z' {
+			return true
+		}
+	}
+	return false
+}
+
+// lowerASCII returns a copy of b with the ASCII letters lowercased. Unlike
+// bytes.ToLower, it never changes the length of b.
+func lowerASCII(b []byte) []byte {
+	out := make([]byte, len(b))
+	for i, c := range b {
+		if c >= 'A' && c <= 'Z' {
+			c += 'a' - 'A'
+		}
+		out[i] = c
+	}
+	return out
+}
+
+// restorePathParams sets the path params of the route pattern to their values in
+// the original request path `raw`, whose lowercased copy was routed. As lowercasing
+// doesn't change the length of the path, the params are at the same offsets in both.
+func restorePathParams(ctx *fasthttp.RequestCtx, pattern string, raw []byte) {
+	pos := 0
+	for i := 0; i < len(pattern); {
+		if pattern[i] != '{' {
+			i++
+			pos++
+			continue
+		}
+
+		var name string
+		name, i = scanPathParam(pattern, i)
+
+		// Optional params that aren't in the path have no values.
+		v, ok := ctx.UserValue(name).(string)
+		if !ok || pos+len(v) > len(raw) {
+			continue
+		}
+		ctx.SetUserValue(name, string(raw[pos:pos+len(v)]))
+		pos += len(v)
+	}
+}
+
+// pathParamNames returns the names of the path params in the route pattern.
+func pathParamNames(pattern string) []string {
+	var out []string
+	for i := 0; i < len(pattern); {
+		if pattern[i] != '{' {
+			i++
+			continue
+		}
+
+		var name string
+		name, i = scanPathParam(pattern, i)
+		out = append(out, name)
+	}
+	return out
+}
+
+// scanPathParam returns the name of the path param that starts at the brace at
+// pattern[i], eg: {id}, {id:[0-9]+} or {id?}, and the offset after it.
+func scanPathParam(pattern string, i int) (string, int) {
+	// Find the closing brace, skipping the ones in the param's regexp, if any.
+	end, depth := i, 0
+	for ; end < len(pattern); end++ {
+		if pattern[end] == '{' {
+			depth++
+		} else if pattern[end] == '}' {
+			if depth--; depth == 0 {
+				break
+			}
+		}
+	}
+
+	name := pattern[i+1 : end]
+	if j := strings.IndexByte(name, ':'); j >= 0 {
+		name = name[:j]
+	}
+	return strings.TrimSuffix(name, "?"), end + 1
+}
+
+// isResponseWritten checks whether a status code or a body has
+// already been written to the response.
+func isResponseWritten(ctx *fasthttp.RequestCtx) bool {
+	return ctx.Response.StatusCode() != fasthttp.StatusOK || ctx.Response.IsBodyStream() || len(ctx.Response.Body()) > 0
+}
+
+// readLimited reads rd to the end and returns ErrBodyTooLarge if it has more
+// than max bytes, without reading (eg: decompressing) more than that.
+func readLimited(rd io.Reader, max int) ([]byte, error) {
+	b, err := ioutil.ReadAll(io.LimitReader(rd, int64(max)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(b) > max {
+		return nil, ErrBodyTooLarge
+	}
+	return b, nil
+}